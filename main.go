@@ -2,20 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"math"
+	"math/big"
 
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/INFURA/go-ethlibs/jsonrpc"
-
 	"github.com/TwiN/go-color"
 	"github.com/dominant-strategies/go-quai/common"
-	"github.com/dominant-strategies/go-quai/consensus/progpow"
 	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/params"
 	"github.com/dominant-strategies/go-quai/quaiclient/ethclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
 	"github.com/dominant-strategies/quai-cpu-miner/util"
 )
@@ -23,63 +37,459 @@ import (
 const (
 	// resultQueueSize is the size of channel listening to sealing result.
 	resultQueueSize = 10
-	maxRetryDelay   = 60 * 60 * 4 // 4 hours
 	USER_AGENT_VER  = "0.1"
+
+	// Queue size and GC tuning used when running in low-memory mode.
+	lowMemoryQueueSize  = 1
+	lowMemoryGCPercent  = 20
+	lowMemoryLimitBytes = 256 << 20 // 256 MiB soft limit
+
+	// How often the CPU temperature is sampled for the cooling hooks.
+	coolingInterval = 10 * time.Second
+
+	// How often and how patiently the nodes are asked whether they have synced.
+	syncCheckInterval = 10 * time.Second
+	syncCheckTimeout  = 5 * time.Second
+
+	// How often --chaos gets a chance to drop the proxy connection.
+	chaosDropInterval = 10 * time.Second
+
+	// Default seconds a clamped header timestamp may drift from the node's
+	// clock, the future drift go-quai accepts, and the clock skew against
+	// NTP worth a warning.
+	defaultTimestampDrift = 15
+	clockSkewWarning      = 2 * time.Second
+	clockSkewTimeout      = 5 * time.Second
+
+	// Default seconds the hashrate is measured for per thread count when
+	// auto-tuning, long enough for the engine's one minute average to settle,
+	// and the gain a thread count must show to replace the current one.
+	defaultAutoTuneTrial = 180
+	autoTuneMargin       = 0.02
+
+	// How often the hashrate is sampled into the moving averages, and
+	// reported.
+	hashrateSampleInterval = 10 * time.Second
+	hashrateReportInterval = 60 * time.Second
+
+	// How often the system load is checked against the guardrails, how long
+	// the load is left to settle after a change, and the cores to spare
+	// before threads are added back.
+	guardrailInterval = 15 * time.Second
+	guardrailSettle   = time.Minute
+	guardrailHeadroom = 0.5
+
+	// How often the desktop is checked for user activity in idle mining.
+	idleInterval = 5 * time.Second
+
+	// Default interval between telemetry reports.
+	defaultTelemetryInterval = 60 * 60 // 1 hour
+
+	// Default interval between stats file snapshots, in seconds.
+	defaultStatsInterval = 60
+
+	// Block explorer link template used when BlocksFile.ExplorerURL is empty.
+	defaultExplorerURL = "https://{location}.colosseum.quaiscan.io/block/{hash}"
+
+	// Default interval between InfluxDB pushes, in seconds.
+	defaultInfluxInterval = 10
+
+	// Interval between exports of finished traces.
+	tracingFlushInterval = 5 * time.Second
+
+	// Default interval between getwork polls, in milliseconds.
+	defaultGetworkInterval = 500
+
+	// Connectivity profile used when none is configured.
+	defaultConnectivity = "default"
+
+	// Default file failed submissions are spooled to.
+	defaultSpoolFile = "submissions.spool"
+
+	// Default interval between policy evaluations, in seconds.
+	defaultPolicyInterval = 10
+	defaultTenantInterval = 600
+
+	// Default dev fee period, in seconds.
+	defaultDevFeePeriod = 60 * 60 // 1 hour
+
+	// Default seconds bench hashes at each thread count.
+	defaultBenchDuration = 10
+	// Share of the best hashrate the recommended thread count must reach.
+	benchRecommendShare = 0.95
+	// Default database bench results are kept in, and the share of the
+	// previous run's hashrate below which a thread count is flagged as a
+	// regression.
+	defaultBenchResults  = "bench.db"
+	benchRegressionShare = 0.9
+	// Backend reported in bench results and telemetry.
+	minerBackend = "cpu-progpow"
+
+	// Default difficulty of --simulate work.
+	defaultSimulateDifficulty = 5000
+	// Getwork poll interval (ms) against a --replay, short enough to catch
+	// sped up work.
+	replayPollInterval = 50
+
+	// Self test work difficulty, poll interval (ms) and default timeout (s).
+	selfTestDifficulty     = 1000
+	selfTestPollInterval   = 100
+	defaultSelfTestTimeout = 120
+
+	// Default time probe-proxy waits for the proxy to push new work, in seconds.
+	defaultProbePushWait = 60
+
+	// How long shutdown waits for in-flight submissions.
+	shutdownTimeout = 10 * time.Second
+
+	// How long a proxy submission waits for its ack.
+	proxyAckTimeout = 30 * time.Second
+
+	// How long a keepalive ping waits for the proxy's reply.
+	proxyPingTimeout = 10 * time.Second
+
+	// Retries of a failed submission to a node context.
+	nodeSubmitRetries = 3
+
+	// How long a proxy submission is retried before it is stale.
+	proxySubmitWindow = time.Minute
+
+	// How often the terminal dashboard is redrawn.
+	dashboardInterval = time.Second
+
+	// How long the miner may go without work before the systemd watchdog
+	// stops being pinged.
+	watchdogWorkAge = 5 * time.Minute
+
+	// How often the sealing loop beats, and how long it may miss beats
+	// before /healthz reports it stalled.
+	heartbeatInterval = 5 * time.Second
+	heartbeatTimeout  = 30 * time.Second
+
+	// Default age of the latest work past which /readyz fails, in seconds.
+	defaultReadyWorkAge = 120
 )
 
+// Wei per QUAI, for pretty printing balances.
+var weiPerQuai = new(big.Float).SetFloat64(1e18)
+
 var (
 	exit = make(chan bool)
+
+	// Dialects detected per proxy URL, so reconnects skip the probe.
+	proxyDialects sync.Map
 )
 
 type Miner struct {
 	// Miner config object
 	config util.Config
 
-	// Progpow consensus engine used to seal a block
-	engine *progpow.Progpow
+	// PoW engine schedule used to seal a block
+	engine util.PowEngine
 
 	// Current header to mine
 	header *types.Header
 
-	// RPC client connection to mining proxy
+	// Numbers, location and zone parent of the latest work, guarded by
+	// statsLock
+	workNumber   [common.HierarchyDepth]uint64
+	workLocation common.Location
+	workParent   common.Hash
+
+	// Receives zone parents found to no longer be the chain tip
+	staleCh chan common.Hash
+
+	// RPC client connection to mining proxy, replaced on reconnect, and the
+	// index of its proxy in the failover list
+	proxyLock   sync.RWMutex
 	proxyClient *util.MinerSession
+	proxyIndex  int
+
+	// Unix nanoseconds of the latest work received and of the sealing
+	// loop's latest heartbeat, updated atomically
+	lastWork  int64
+	heartbeat int64
+
+	// Networking tuning and the spool of undelivered submissions
+	profile util.ConnectivityProfile
+	spool   *util.Spool
 
 	// RPC client connections to the Quai nodes
 	sliceClients SliceClients
 
+	// HTTP client for getwork-style work sources
+	getworkClient *util.GetworkClient
+
+	// Optional keystore key used to sign proxy logins and submissions
+	signer *util.Signer
+
+	// Reward address the proxy session is logged in with, and the optional
+	// rotation that replaces it after every found block
+	rewardLock    sync.Mutex
+	rewardAddress string
+	rotator       *util.AddressRotator
+	tenants       *util.Tenants
+
+	// Set while the session mines to the dev fee address
+	devFeeActive bool
+	// Set while the hashrate is below the low hashrate hook's threshold
+	lowHashrate bool
+	// Optional stats file appended every StatsFile.Interval
+	stats *util.StatsWriter
+	// Dev fee blocks found and nanoseconds spent mining for the dev fee
+	devFeeBlocks uint64
+	devFeeTime   int64
+
+	// Blocks found this session, per context
+	blocksFound [common.HierarchyDepth]uint64
+	// Found blocks whose coinbase credit the balance reports have yet to
+	// settle, guarded by statsLock
+	ledger []ledgerBlock
+
+	// 1 while connected to the work source, updated atomically
+	connected int32
+
+	// Session start, set once shutdown begins, and the hashrate trend of
+	// the session
+	start       time.Time
+	stopping    int32
+	quit        chan struct{}
+	submissions sync.WaitGroup
+	statsLock   sync.Mutex
+	hashrates   util.HashrateStats
+
+	// Accepted and failed submissions, reconnections and the age in nanoseconds of the
+	// latest work when sealing started, updated atomically
+	submitAccepts uint64
+	submitRejects uint64
+	submitStale   uint64
+	submitErrors  uint64
+	reconnects    uint64
+	workLatency   int64
+
+	// Blocks some but not all of whose node contexts accepted them,
+	// solutions failing local verification and solutions for already solved
+	// work, updated atomically
+	partialSubmissions uint64
+	invalidSolutions   uint64
+	duplicateSolutions uint64
+	submitted          util.SubmittedSet
+
+	// Optional record of found blocks and submissions, and the Unix
+	// nanoseconds sealing last started, updated atomically
+	history   *util.History
+	sealStart int64
+
+	// Optional log of found blocks, shared with the zone miners
+	blocks *util.BlocksWriter
+
+	// 1 while sealing is paused, updated atomically, and the reasons
+	// holding it paused, guarded by pauseLock
+	paused       int32
+	pauseLock    sync.Mutex
+	pauseReasons map[string]bool
+
+	// Thread cap of Guardrails.MaxCPUPercent enforced by SetThreads, 0 when
+	// uncapped, updated atomically
+	threadCeiling int32
+
+	// Optional scripted pause/thread policy
+	policy *util.Policy
+
+	// Optional tracer of the work lifecycle, shared with the zone miners
+	tracer *util.Tracer
+
 	// Channel to receive header updates
 	updateCh chan *types.Header
 
 	// Channel to submit completed work
 	resultCh chan *types.Header
 
+	// Channel to pause (true) or resume (false) sealing
+	pauseCh chan bool
+
 	// Track previous block number for pretty printing
 	previousNumber [common.HierarchyDepth]uint64
 
-	// Tracks the latest JSON RPC ID to send to the proxy or node.
-	latestId uint64
+	// Miners for the other configured Locations, sharing the threads
+	zones []*Miner
+
+	// Optional terminal dashboard replacing the log scroll
+	dashboard *util.Dashboard
+
+	// Flags and config file the miner started with, compared against when
+	// the config is reloaded
+	reloadLock sync.Mutex
+	opts       mineOptions
+	loaded     util.Config
+}
+
+// hookEvent is the JSON payload handed to external hooks.
+type hookEvent struct {
+	Event       string     `json:"event"`
+	Time        int64      `json:"time"`
+	Location    []int      `json:"location"`
+	Temperature float64    `json:"temperature,omitempty"`
+	Hashrate    float64    `json:"hashrate,omitempty"`
+	Block       *hookBlock `json:"block,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+}
+
+// hookBlock describes a found block in hook payloads.
+type hookBlock struct {
+	Order    int         `json:"order"`
+	Number   []*big.Int  `json:"number"`
+	Hash     common.Hash `json:"hash"`
+	SealHash common.Hash `json:"sealHash"`
+	Nonce    uint64      `json:"nonce"`
 }
 
 // Clients for RPC connection to the Prime, region, & zone ports belonging to the
 // slice we are actively mining
 type SliceClients [common.HierarchyDepth]*ethclient.Client
 
-// Creates a MinerSession object that is connected to the single proxy node.
-func connectToProxy(config util.Config) *util.MinerSession {
+// startZoneMiner mines another location with its own node clients, engine
+// and pipeline.
+func (m *Miner) startZoneMiner(location common.Location, threads int) *Miner {
+	config := m.config
+	config.Location = location
+	if config.Discovery.Enabled() {
+		util.DiscoverNodes(&config)
+	}
+	engine, err := util.NewEngineSchedule(config.Engines)
+	if err != nil {
+		log.Fatal("Invalid engine schedule: ", err)
+	}
+	engine.SetThreads(threads)
+	var zoneEngine util.PowEngine = engine
+	if percent := dutyCycle(config); percent > 0 && percent < 100 {
+		zoneEngine = util.NewThrottledEngine(engine, percent)
+	}
+	z := &Miner{
+		config:        config,
+		engine:        zoneEngine,
+		header:        types.EmptyHeader(),
+		profile:       m.profile,
+		updateCh:      make(chan *types.Header, cap(m.updateCh)),
+		resultCh:      make(chan *types.Header, cap(m.resultCh)),
+		pauseCh:       make(chan bool),
+		rewardAddress: config.RewardAddressFor(location),
+		start:         time.Now(),
+		quit:          make(chan struct{}),
+		tracer:        m.tracer,
+		blocks:        m.blocks,
+	}
+	z.sliceClients = connectToSlice(config)
+	z.connected = 1
+	z.checkNodeLocation()
+	z.checkNetwork()
+	z.waitForSync()
+	go z.fetchPendingHeaderNode()
+	go z.subscribeNode()
+	if config.TipCheckInterval > 0 {
+		z.staleCh = make(chan common.Hash)
+		go z.tipCheckLoop()
+	}
+	go z.resultLoop()
+	go z.miningLoop()
+	go z.hashratePrinter()
+	if config.BalanceInterval > 0 {
+		go z.balanceLoop()
+	}
+	log.Println("Mining location", location, "with", threads, "threads")
+	return z
+}
+
+// splitThreads divides total threads, 0 meaning every core, between the
+// configured Locations by weight, giving each at least one.
+func (m *Miner) splitThreads(total int) []int {
+	if total <= 0 {
+		total = runtime.GOMAXPROCS(0)
+	}
+	weights := 0
+	for _, zone := range m.config.Locations {
+		weights += zoneWeight(zone)
+	}
+	threads := make([]int, len(m.config.Locations))
+	remainders := make([]int, len(m.config.Locations))
+	left := total
+	for i, zone := range m.config.Locations {
+		threads[i] = total * zoneWeight(zone) / weights
+		remainders[i] = total * zoneWeight(zone) % weights
+		left -= threads[i]
+	}
+	// Hand the threads lost to rounding down to the zones that lost the most.
+	order := make([]int, len(threads))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for _, i := range order[:left] {
+		threads[i]++
+	}
+	for i := range threads {
+		if threads[i] < 1 {
+			threads[i] = 1
+		}
+	}
+	return threads
+}
+
+func zoneWeight(zone util.ZoneLocation) int {
+	if zone.Weight <= 0 {
+		return 1
+	}
+	return zone.Weight
+}
+
+// Creates a MinerSession object connected to the first reachable proxy,
+// trying them in priority order starting at index start. It returns the
+// session and the index of its proxy.
+func connectToProxy(config util.Config, profile util.ConnectivityProfile, start int) (*util.MinerSession, int) {
+	urls := config.ProxyList()
+	if len(urls) == 0 {
+		log.Fatal("No ProxyURL configured")
+	}
+	index := start % len(urls)
 	proxyConnected := false
 	var client *util.MinerSession
 	var err error
+	var tlsConfig *tls.Config
+	if config.ProxyTLS.Enabled {
+		tlsConfig, err = util.NewClientTLSConfig(config.ProxyTLS)
+		if err != nil {
+			log.Fatal("Invalid proxy TLS config: ", err)
+		}
+	}
+	dialer := outboundDialer(config)
+	backoff := profile.ConnectBackoff()
 	for !proxyConnected {
-		if config.ProxyURL != "" && !proxyConnected {
-			client, err = util.NewMinerConn(config.ProxyURL)
-			if err != nil {
-				log.Println("Unable to connect to proxy: ", config.ProxyURL)
-			} else {
-				proxyConnected = true
-			}
+		client, err = util.NewMinerConn(urls[index], dialer, tlsConfig, profile.KeepAlive)
+		if err != nil {
+			delay := backoff.Next()
+			log.Warnln("Unable to connect to proxy: ", urls[index], err, "retrying in", delay.Round(time.Millisecond))
+			index = (index + 1) % len(urls)
+			time.Sleep(delay)
+		} else {
+			proxyConnected = true
+		}
+	}
+	if len(urls) > 1 {
+		log.WithField("proxy", urls[index]).Infoln("Connected to proxy", urls[index])
+	}
+	switch config.ProxyDialect {
+	case "", util.DialectAuto:
+		if dialect, ok := proxyDialects.Load(urls[index]); ok {
+			client.SetDialect(dialect.(string))
+		} else if dialect, ok := client.DetectDialect(); ok {
+			proxyDialects.Store(urls[index], dialect)
+		}
+	default:
+		if err := client.SetDialect(config.ProxyDialect); err != nil {
+			log.Fatal("Invalid proxy dialect: ", err)
 		}
 	}
-	return client
+	return client, index
 }
 
 // connectToSlice takes in a config and retrieves the Prime, Region, and Zone client
@@ -91,27 +501,32 @@ func connectToSlice(config util.Config) SliceClients {
 	primeConnected := false
 	regionConnected := false
 	zoneConnected := false
-	for !primeConnected || !regionConnected || !zoneConnected {
+	dialer := outboundDialer(config)
+	backoff := util.ConnectivityProfiles[config.Connectivity].ConnectBackoff()
+	for attempt := 0; !primeConnected || !regionConnected || !zoneConnected; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff.Next())
+		}
 		if config.PrimeURL != "" && !primeConnected {
-			clients[common.PRIME_CTX], err = ethclient.Dial(config.PrimeURL)
+			clients[common.PRIME_CTX], err = dialNode(dialer, config.PrimeURL)
 			if err != nil {
-				log.Println("Unable to connect to node:", "Prime", config.PrimeURL)
+				log.Warnln("Unable to connect to node:", "Prime", config.PrimeURL)
 			} else {
 				primeConnected = true
 			}
 		}
 		if config.RegionURLs[loc.Region()] != "" && !regionConnected {
-			clients[common.REGION_CTX], err = ethclient.Dial(config.RegionURLs[loc.Region()])
+			clients[common.REGION_CTX], err = dialNode(dialer, config.RegionURLs[loc.Region()])
 			if err != nil {
-				log.Println("Unable to connect to node:", "Region", config.RegionURLs[loc.Region()])
+				log.Warnln("Unable to connect to node:", "Region", config.RegionURLs[loc.Region()])
 			} else {
 				regionConnected = true
 			}
 		}
 		if config.ZoneURLs[loc.Region()][loc.Zone()] != "" && !zoneConnected {
-			clients[common.ZONE_CTX], err = ethclient.Dial(config.ZoneURLs[loc.Region()][loc.Zone()])
+			clients[common.ZONE_CTX], err = dialNode(dialer, config.ZoneURLs[loc.Region()][loc.Zone()])
 			if err != nil {
-				log.Println("Unable to connect to node:", "Zone", config.ZoneURLs[loc.Region()][loc.Zone()])
+				log.Warnln("Unable to connect to node:", "Zone", config.ZoneURLs[loc.Region()][loc.Zone()])
 			} else {
 				zoneConnected = true
 			}
@@ -120,278 +535,3575 @@ func connectToSlice(config util.Config) SliceClients {
 	return clients
 }
 
-func init() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+// outboundDialer dials through the configured outbound proxy, which
+// validateConfig has already checked.
+func outboundDialer(config util.Config) *util.Dialer {
+	dialer, err := util.NewDialer(config.OutboundProxy)
+	if err != nil {
+		log.Fatal("Invalid OutboundProxy: ", err)
+	}
+	return dialer
 }
 
-func main() {
-	// Load config
-	config, err := util.LoadConfig("..")
+// dialNode connects a client to the node at url.
+func dialNode(dialer *util.Dialer, url string) (*ethclient.Client, error) {
+	client, err := dialer.DialNode(context.Background(), url)
 	if err != nil {
-		log.Print("Could not load config: ", err)
-		return
+		return nil, err
 	}
-	// Parse mining location from args
-	if len(os.Args) > 2 {
-		raw := os.Args[1:3]
-		region, _ := strconv.Atoi(raw[0])
-		zone, _ := strconv.Atoi(raw[1])
-		config.Location = common.Location{byte(region), byte(zone)}
+	return ethclient.NewClient(client), nil
+}
+
+// mineOptions are the mine command's flags, each overriding the config file
+// when set.
+type mineOptions struct {
+	region   int
+	zone     int
+	proxyURL string
+	network  string
+	preset   string
+	threads  int
+	capture  string
+	dryRun   bool
+	service  string
+	tui      bool
+	stats    string
+
+	simulate           bool
+	simulateDifficulty int64
+
+	record      string
+	replay      string
+	replaySpeed float64
+
+	chaos float64
+}
+
+// Flags shared by every command.
+var (
+	configPath string
+	logLevel   string
+	logFormat  string
+)
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(2)
 	}
-	// Build manager config
-	blake3Config := progpow.Config{
-		NotifyFull: true,
+}
+
+// newRootCommand builds the CLI. Without a subcommand the miner mines.
+func newRootCommand() *cobra.Command {
+	var opts mineOptions
+	mineFlags := pflag.NewFlagSet("mine", pflag.ExitOnError)
+	mineFlags.IntVar(&opts.region, "region", -1, "region to mine (default from config, or detected from the zone node)")
+	mineFlags.IntVar(&opts.zone, "zone", -1, "zone to mine (default from config, or detected from the zone node)")
+	mineFlags.StringVar(&opts.proxyURL, "proxy-url", "", "mine through the proxy at this address (default from config)")
+	mineFlags.StringVar(&opts.network, "network", "", "fill in the local node and proxy addresses left empty in the config for colosseum, garden, orchard or local, and check the nodes run it (default from config)")
+	mineFlags.StringVar(&opts.preset, "preset", "", "intensity preset: eco, balanced or max (default from config)")
+	mineFlags.IntVar(&opts.threads, "threads", 0, "number of sealing threads (default from config, else every available core)")
+	mineFlags.BoolVar(&opts.dryRun, "dry-run", false, "seal work as usual but log solutions instead of submitting them")
+	mineFlags.StringVar(&opts.stats, "stats-file", "", "append periodic stats snapshots to this CSV file, or JSON lines for .json/.jsonl (default from config)")
+	mineFlags.StringVar(&opts.capture, "capture", "", "record work and submissions into a support bundle (.tar.gz) at this path")
+	mineFlags.BoolVar(&opts.simulate, "simulate", false, "mine synthetic work from an in-process work source instead of a node or proxy")
+	mineFlags.Int64Var(&opts.simulateDifficulty, "simulate-difficulty", defaultSimulateDifficulty, "difficulty of the --simulate work")
+	mineFlags.StringVar(&opts.record, "record", "", "record every work package and submission to this file for --replay")
+	mineFlags.StringVar(&opts.replay, "replay", "", "mine the work stream of a --record file or --capture bundle instead of a node or proxy, then stop")
+	mineFlags.Float64Var(&opts.replaySpeed, "replay-speed", 1, "pace --replay this many times faster than recorded")
+	mineFlags.Float64Var(&opts.chaos, "chaos", 0, "soak test: drop the proxy connection, delay work, corrupt proxy messages and fail submissions, each with this probability (0 to 1) at every chance")
+	mineFlags.BoolVar(&opts.tui, "tui", false, "show a live terminal dashboard instead of the log scroll")
+	mineFlags.StringVar(&opts.service, "service", "", "manage the Windows service: install, uninstall, start or stop")
+	mine := func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			if err := opts.applyPositional(cmd, args); err != nil {
+				return err
+			}
+		}
+		if opts.service != "" {
+			os.Exit(runService(opts.service))
+		}
+		if util.RunningAsService() {
+			return util.RunService(func() { runMine(opts) }, func() { exit <- true })
+		}
+		runMine(opts)
+		return nil
 	}
-	blake3Engine := progpow.New(blake3Config, nil, false)
-	m := &Miner{
-		config:         config,
-		engine:         blake3Engine,
-		header:         types.EmptyHeader(),
-		updateCh:       make(chan *types.Header, resultQueueSize),
-		resultCh:       make(chan *types.Header, resultQueueSize),
-		previousNumber: [common.HierarchyDepth]uint64{0, 0, 0},
+
+	root := &cobra.Command{
+		Use:          "quai-cpu-miner",
+		Short:        "CPU miner for Quai Network",
+		Args:         cobra.RangeArgs(0, 3),
+		SilenceUsage: true,
+		RunE:         mine,
 	}
-	log.Println("Starting Quai cpu miner in location ", config.Location)
-	if config.Proxy {
-		m.proxyClient = connectToProxy(config)
-		go m.fetchPendingHeaderProxy()
-		go m.startProxyListener()
-		go m.subscribeProxy()
-	} else {
-		m.sliceClients = connectToSlice(config)
-		go m.fetchPendingHeaderNode()
-		// No separate call needed to start listeners.
-		go m.subscribeNode()
+	root.PersistentFlags().StringVar(&configPath, "config", "", "config file (default config/config.yaml, else ./config.yaml)")
+	root.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level: debug, info, warn or error (default from config, else info)")
+	root.PersistentFlags().StringVar(&logFormat, "log-format", "", "log format: text or json (default from config, else text)")
+	root.Flags().AddFlagSet(mineFlags)
+
+	mineCmd := &cobra.Command{
+		Use:   "mine",
+		Short: "Mine against the configured nodes, proxy or getwork endpoint (the default)",
+		Args:  cobra.NoArgs,
+		RunE:  mine,
 	}
-	go m.resultLoop()
-	go m.miningLoop()
-	go m.hashratePrinter()
-	<-exit
+	mineCmd.Flags().AddFlagSet(mineFlags)
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the config file",
+	}
+	configCmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Check the config file for errors without mining",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runConfigValidate())
+		},
+	})
+
+	var historyLimit int
+	var historyContext string
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List found blocks and their submissions from the history database",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runHistory(historyLimit, historyContext))
+		},
+	}
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "number of blocks to list, newest first")
+	historyCmd.Flags().StringVar(&historyContext, "context", "", "only list blocks of this context: prime, region or zone")
+
+	var benchResults string
+	benchCmd := &cobra.Command{
+		Use:   "bench [secondsPerStep] [maxThreads]",
+		Short: "Measure the hashrate at every thread count",
+		Args:  cobra.MaximumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runBench(args, benchResults))
+		},
+	}
+	benchCmd.Flags().StringVar(&benchResults, "results", defaultBenchResults, "database the results are kept in and compared against the previous run on this machine, empty to keep none")
+
+	root.AddCommand(
+		mineCmd,
+		configCmd,
+		historyCmd,
+		benchCmd,
+		&cobra.Command{
+			Use:   "version",
+			Short: "Print the miner version",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				fmt.Println("quai-cpu-miner", USER_AGENT_VER)
+			},
+		},
+		&cobra.Command{
+			Use:   "verify <header.json> [nonce] [mixHash]",
+			Short: "Verify a sealed header's proof of work",
+			Args:  cobra.RangeArgs(1, 3),
+			Run: func(cmd *cobra.Command, args []string) {
+				os.Exit(runVerify(args))
+			},
+		},
+		&cobra.Command{
+			Use:   "selftest [timeoutSeconds]",
+			Short: "Mine and verify a block against an in-process work source",
+			Args:  cobra.MaximumNArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				os.Exit(runSelfTest(args))
+			},
+		},
+		&cobra.Command{
+			Use:   "probe-proxy",
+			Short: "Check the configured proxy's login, work and submission methods",
+			Run: func(cmd *cobra.Command, args []string) {
+				config, err := loadConfig()
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				os.Exit(runProbeProxy(config, args))
+			},
+		},
+	)
+	return root
 }
 
-// subscribeProxy subscribes to the head of the mining nodes in order to pass
-// the most up to date block to the miner within the manager.
-func (m *Miner) subscribeProxy() error {
-	address := m.config.RewardAddress
-	password := m.config.Password
+// applyPositional reads the deprecated "<region> <zone>" arguments of the
+// launch scripts predating --region and --zone. A third argument they used to
+// pass is ignored.
+func (opts *mineOptions) applyPositional(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("unknown command %q, pass the location as --region and --zone", args[0])
+	}
+	if cmd.Flags().Changed("region") || cmd.Flags().Changed("zone") {
+		return errors.New("the positional region and zone can't be combined with --region and --zone")
+	}
+	region, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid region %q", args[0])
+	}
+	zone, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid zone %q", args[1])
+	}
+	opts.region, opts.zone = region, zone
+	log.Warnln("Positional region and zone arguments are deprecated and will be removed in the next release, use --region", region, "--zone", zone)
+	return nil
+}
 
-	msg, err := jsonrpc.MakeRequest(int(m.incrementLatestID()), "quai_submitLogin", address, password)
+// loadConfig reads the config file and sets up logging from it and the
+// logging flags.
+func loadConfig() (util.Config, error) {
+	config, deprecated, err := readConfig()
 	if err != nil {
-		log.Fatalf("Unable to create login request: %v", err)
+		return config, err
+	}
+	if err := setupLogging(config); err != nil {
+		return config, err
 	}
+	for _, warning := range deprecated {
+		log.Warnln(warning)
+	}
+	return config, nil
+}
 
-	return m.proxyClient.SendTCPRequest(*msg)
+// readConfig reads the config file, moving deprecated settings to their
+// replacements with a warning for each.
+func readConfig() (util.Config, []string, error) {
+	config, err := util.LoadConfig(configPath)
+	if err != nil {
+		return config, nil, fmt.Errorf("could not load config: %w", err)
+	}
+	deprecated, err := config.MigrateDeprecated()
+	if err != nil {
+		return config, nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, deprecated, nil
 }
 
-func (m *Miner) startProxyListener() {
-	m.proxyClient.ListenTCP(m.updateCh)
+// logSettings returns the log level and format the config sets, overridden by
+// the logging flags.
+func logSettings(config util.Config) (level, format string) {
+	level, format = logLevel, logFormat
+	if level == "" {
+		level = config.LogLevel
+	}
+	if format == "" {
+		format = config.LogFormat
+	}
+	return level, format
 }
 
-// Subscribes to the zone node in order to get pending header updates.
-func (m *Miner) subscribeNode() {
-	if _, err := m.sliceClients[common.ZONE_CTX].SubscribePendingHeader(context.Background(), m.updateCh); err != nil {
-		log.Fatal("Failed to subscribe to pending header events", err)
+// setupLogging sets up logging from the config and the logging flags.
+func setupLogging(config util.Config) error {
+	if err := util.SetupLogging(logSettings(config)); err != nil {
+		return fmt.Errorf("invalid logging config: %w", err)
 	}
+	return nil
 }
 
-// Gets the latest pending header from the proxy.
-// This only runs upon initialization, further proxy pending headers are received in listenTCP.
-func (m *Miner) fetchPendingHeaderProxy() {
-	retryDelay := 1 // Start retry at 1 second
-	for {
-		msg, err := jsonrpc.MakeRequest(int(m.incrementLatestID()), "quai_getPendingHeader", nil)
-		if err != nil {
-			log.Fatalf("Unable to make pending header request: %v", err)
+// apply overrides the config with the flags that were set.
+func (opts mineOptions) apply(config *util.Config) error {
+	if opts.region >= 0 || opts.zone >= 0 {
+		location := common.Location{0, 0}
+		if len(config.Location) == common.HierarchyDepth-1 {
+			location = append(common.Location(nil), config.Location...)
 		}
-		err = m.proxyClient.SendTCPRequest(*msg)
-		header := <-m.updateCh
-
-		if err != nil {
-			log.Println("Pending block not found error: ", err)
-			time.Sleep(time.Duration(retryDelay) * time.Second)
-			retryDelay *= 2
-			if retryDelay > maxRetryDelay {
-				retryDelay = maxRetryDelay
+		for i, index := range []int{opts.region, opts.zone} {
+			if index > math.MaxUint8 {
+				return fmt.Errorf("invalid location index %d", index)
+			}
+			if index >= 0 {
+				location[i] = byte(index)
 			}
-		} else {
-			m.updateCh <- header
-			break
 		}
+		config.Location = location
+		config.Locations = nil
 	}
-}
-
-// Gets the latest pending header from the zone client.
-func (m *Miner) fetchPendingHeaderNode() {
-	retryDelay := 1 // Start retry at 1 second
-	for {
-		header, err := m.sliceClients[common.ZONE_CTX].GetPendingHeader(context.Background())
-		if err != nil {
-			log.Println("Pending block not found error: ", err)
-			time.Sleep(time.Duration(retryDelay) * time.Second)
-			retryDelay *= 2
-			if retryDelay > maxRetryDelay {
-				retryDelay = maxRetryDelay
-			}
-		} else {
-			m.updateCh <- header
-			break
+	if opts.proxyURL != "" {
+		config.Proxy = true
+		config.ProxyURL = opts.proxyURL
+	}
+	if opts.preset != "" {
+		config.Preset = opts.preset
+	}
+	if opts.threads != 0 {
+		config.Threads = opts.threads
+	}
+	if opts.chaos < 0 || opts.chaos > 1 {
+		return fmt.Errorf("invalid chaos probability %g", opts.chaos)
+	}
+	if opts.simulate && opts.simulateDifficulty <= 0 {
+		return fmt.Errorf("invalid simulate difficulty %d", opts.simulateDifficulty)
+	}
+	if opts.replay != "" {
+		if opts.simulate {
+			return errors.New("--replay and --simulate are exclusive")
 		}
+		if opts.replaySpeed <= 0 {
+			return fmt.Errorf("invalid replay speed %g", opts.replaySpeed)
+		}
+	}
+	if opts.simulate || opts.replay != "" {
+		// runMine points the getwork client at the simulated or replayed
+		// work source.
+		config.Proxy = false
+		config.Locations = nil
+		config.Listeners.Getwork = util.Listener{}
+		if len(config.Location) == 0 {
+			config.Location = common.Location{0, 0}
+		}
+	}
+	if opts.dryRun {
+		config.DryRun = true
 	}
+	if opts.stats != "" {
+		config.StatsFile.Path = opts.stats
+	}
+	if opts.network != "" {
+		config.Network = opts.network
+	}
+	return config.ApplyNetwork()
 }
 
-// miningLoop iterates on a new header and passes the result to m.resultCh. The result is called within the method.
-func (m *Miner) miningLoop() error {
-	var (
-		stopCh chan struct{}
-	)
-	// interrupt aborts the in-flight sealing task.
-	interrupt := func() {
-		if stopCh != nil {
-			close(stopCh)
-			stopCh = nil
-		}
+// runHistory prints the blocks recorded in the history database.
+func runHistory(limit int, context string) int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println(err)
+		return 1
 	}
-	for {
-		select {
-		case header := <-m.updateCh:
-			// Mine the header here
-			// Return the valid header with proper nonce and mix digest
-			// Interrupt previous sealing operation
-			interrupt()
-			stopCh = make(chan struct{})
-			number := [common.HierarchyDepth]uint64{header.NumberU64(common.PRIME_CTX), header.NumberU64(common.REGION_CTX), header.NumberU64(common.ZONE_CTX)}
-			primeStr := fmt.Sprint(number[common.PRIME_CTX])
-			regionStr := fmt.Sprint(number[common.REGION_CTX])
-			zoneStr := fmt.Sprint(number[common.ZONE_CTX])
-			if number != m.previousNumber {
-				if number[common.PRIME_CTX] != m.previousNumber[common.PRIME_CTX] {
-					primeStr = color.Ize(color.Red, primeStr)
-					regionStr = color.Ize(color.Red, regionStr)
-					zoneStr = color.Ize(color.Red, zoneStr)
-				} else if number[common.REGION_CTX] != m.previousNumber[common.REGION_CTX] {
-					regionStr = color.Ize(color.Yellow, regionStr)
-					zoneStr = color.Ize(color.Yellow, zoneStr)
-				} else if number[common.ZONE_CTX] != m.previousNumber[common.ZONE_CTX] {
-					zoneStr = color.Ize(color.Blue, zoneStr)
-				}
-				log.Println("Mining Block: ", fmt.Sprintf("[%s %s %s]", primeStr, regionStr, zoneStr), "location", header.Location(), "difficulty", header.Difficulty())
+	if config.HistoryDB == "" {
+		fmt.Println("No HistoryDB configured")
+		return 1
+	}
+	if _, ok := util.ContextIndex(context); !ok {
+		fmt.Println("Unknown context:", context)
+		return 2
+	}
+	history, err := util.OpenHistory(config.HistoryDB)
+	if err != nil {
+		fmt.Println("Unable to open history database:", err)
+		return 1
+	}
+	defer history.Close()
+	blocks, err := history.Blocks(limit, context)
+	if err != nil {
+		fmt.Println("Unable to read history:", err)
+		return 1
+	}
+	for _, b := range blocks {
+		fmt.Printf("%s %-6s %v location %v %s sealed in %s nonce %d\n", b.Found.Format(time.RFC3339), b.Context, b.Number, b.Location, b.Hash.Hex(), b.Sealing.Round(time.Millisecond), b.Nonce)
+		if len(b.Submissions) == 0 {
+			fmt.Println("    never submitted")
+		}
+		for _, s := range b.Submissions {
+			outcome := color.Ize(color.Green, "accepted")
+			if !s.Accepted {
+				outcome = color.Ize(color.Red, "rejected")
 			}
-			m.previousNumber = [common.HierarchyDepth]uint64{header.NumberU64(common.PRIME_CTX), header.NumberU64(common.REGION_CTX), header.NumberU64(common.ZONE_CTX)}
-			header.SetTime(uint64(time.Now().Unix()))
-			if err := m.engine.Seal(header, m.resultCh, stopCh); err != nil {
-				log.Println("Block sealing failed", "err", err)
+			if s.Error != "" {
+				outcome += ": " + s.Error
 			}
+			fmt.Printf("    %s %-8s %s\n", s.Time.Format(time.RFC3339), s.Target, outcome)
 		}
 	}
+	return 0
 }
 
-// WatchHashRate is a simple method to watch the hashrate of our miner and log the output.
-func (m *Miner) hashratePrinter() {
-	ticker := time.NewTicker(60 * time.Second)
-	toSiUnits := func(hr float64) (float64, string) {
-		reduced := hr
-		order := 0
-		for {
-			if reduced >= 1000 {
-				reduced /= 1000
-				order += 3
-			} else {
-				break
-			}
-		}
-		switch order {
-		case 3:
-			return reduced, "Kh/s"
-		case 6:
-			return reduced, "Mh/s"
-		case 9:
-			return reduced, "Gh/s"
-		case 12:
-			return reduced, "Th/s"
-		default:
-			// If reduction didn't work, just return the original
-			return hr, "h/s"
+// validateConfig checks the settings that would otherwise stop the miner
+// after it started.
+func validateConfig(config util.Config) error {
+	if config.Threads < 0 {
+		return fmt.Errorf("invalid thread count %d", config.Threads)
+	}
+	if config.Network != "" {
+		if _, ok := util.Networks[config.Network]; !ok {
+			return fmt.Errorf("unknown network %q", config.Network)
 		}
 	}
-	for {
-		select {
-		case <-ticker.C:
-			hashRate := m.engine.Hashrate()
-			hr, units := toSiUnits(hashRate)
-			log.Println("Current hashrate: ", hr, units)
+	switch config.Timestamp.Mode {
+	case "", util.TimestampOn, util.TimestampOff, util.TimestampClamp:
+	default:
+		return fmt.Errorf("unknown timestamp mode %q", config.Timestamp.Mode)
+	}
+	if config.Preset != "" {
+		if _, ok := util.Presets[config.Preset]; !ok {
+			return fmt.Errorf("unknown preset %q", config.Preset)
 		}
 	}
-}
-
-// resultLoop takes in the result and passes to the proper channels for receiving.
-func (m *Miner) resultLoop() {
+	if len(config.Locations) > 0 && (config.Proxy || config.GetworkURL != "") {
+		return errors.New("Locations are only supported when mining against nodes")
+	}
+	if !config.Proxy && config.GetworkURL == "" {
+		locations := []common.Location{config.Location}
+		if len(config.Locations) > 0 {
+			locations = nil
+			for _, zone := range config.Locations {
+				locations = append(locations, zone.Location)
+			}
+		}
+		if len(config.Locations) == 0 && len(config.Location) == 0 && config.Discovery.Enabled() {
+			return errors.New("Location is needed to discover nodes")
+		}
+		for _, location := range locations {
+			if len(location) == 0 && len(config.Locations) == 0 {
+				// Detected from the zone nodes at startup.
+				continue
+			}
+			if len(location) != common.HierarchyDepth-1 {
+				return fmt.Errorf("invalid location %v", location)
+			}
+			if location.Region() >= len(config.RegionURLs) || location.Region() >= len(config.ZoneURLs) || location.Zone() >= len(config.ZoneURLs[location.Region()]) {
+				return fmt.Errorf("no node URLs configured for location %v", location)
+			}
+		}
+	}
+	if _, err := util.NewDialer(config.OutboundProxy); err != nil {
+		return fmt.Errorf("invalid OutboundProxy: %w", err)
+	}
+	if config.AutoTune.Enabled && (config.Guardrails.MaxLoad > 0 || config.Policy.Script != "" || len(config.Locations) > 1) {
+		return errors.New("AutoTune can't share the thread count with the load guardrail, a policy script or several Locations")
+	}
+	if len(config.CPUAffinity) > 0 && len(config.ExcludeCores) > 0 {
+		return errors.New("CPUAffinity and ExcludeCores can't be combined")
+	}
+	if err := util.CheckCores(config.ExcludeCores); err != nil {
+		return fmt.Errorf("invalid ExcludeCores: %w", err)
+	}
+	if err := util.CheckCores(config.CPUAffinity); err != nil {
+		return fmt.Errorf("invalid CPUAffinity: %w", err)
+	}
+	if config.Connectivity != "" {
+		if _, ok := util.ConnectivityProfiles[config.Connectivity]; !ok {
+			return fmt.Errorf("unknown connectivity profile %q", config.Connectivity)
+		}
+	}
+	if len(config.Tenants) > 0 && (!config.Proxy || len(config.RewardAddresses) > 0) {
+		return errors.New("Tenants are only supported when mining through a proxy, without RewardAddresses")
+	}
+	if _, ok := util.ContextIndex(config.BlockWebhook.Context); !ok {
+		return fmt.Errorf("unknown block webhook context %q", config.BlockWebhook.Context)
+	}
+	switch config.RewardRotation {
+	case "", util.RotateBlock, util.RotateJob:
+	default:
+		return fmt.Errorf("unknown reward rotation %q", config.RewardRotation)
+	}
+	if len(config.ZoneRewardAddresses) > 0 && !config.Proxy {
+		// Nodes seal their own coinbase into the pending header.
+		return errors.New("ZoneRewardAddresses are only supported when mining through a proxy")
+	}
+	for _, zone := range config.ZoneRewardAddresses {
+		location := zone.Location
+		if len(location) != common.HierarchyDepth-1 || location.Region() >= common.NumRegionsInPrime || location.Zone() >= common.NumZonesInRegion {
+			return fmt.Errorf("invalid reward address location %v", location)
+		}
+		if !common.IsHexAddress(zone.Address) {
+			return fmt.Errorf("invalid reward address %q for location %v", zone.Address, location)
+		}
+		if !location.ContainsAddress(common.HexToAddress(zone.Address)) {
+			return fmt.Errorf("reward address %s is not in zone %v", zone.Address, location)
+		}
+	}
+	if config.DevFee.Percent > 0 && (config.DevFee.Percent >= 100 || !common.IsHexAddress(config.DevFee.Address)) {
+		return errors.New("invalid dev fee: Percent must be below 100 and Address a valid address")
+	}
+	if config.Listeners.Getwork.Enabled() && (config.Proxy || config.GetworkURL != "" || len(config.Locations) > 1) {
+		return errors.New("the getwork bridge only serves work from a single zone node")
+	}
+	if config.Listeners.SNMP.Enabled() && config.SNMPCommunity == "" {
+		return errors.New("the SNMP listener needs an SNMPCommunity")
+	}
+	switch config.Cooling.Action {
+	case "", util.CoolingPause, util.CoolingThrottle:
+	default:
+		return fmt.Errorf("unknown cooling action %q", config.Cooling.Action)
+	}
+	if config.Cooling.Action != "" && config.Cooling.HighTemp <= 0 {
+		return errors.New("the cooling action needs a HighTemp")
+	}
+	if config.Idle.MaxLoad > 0 && config.Idle.After <= 0 {
+		return errors.New("Idle.MaxLoad needs an Idle.After period")
+	}
+	if config.TestDifficulty > 0 && (config.Proxy || config.GetworkURL != "") {
+		return errors.New("TestDifficulty is only allowed when mining against a local node")
+	}
+	return nil
+}
+
+// runConfigValidate loads and validates the config file, reporting the first
+// problem found.
+func runConfigValidate() int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Println(color.Ize(color.Red, "FAIL: "), err)
+		return 1
+	}
+	if err := validateConfig(config); err != nil {
+		fmt.Println(color.Ize(color.Red, "FAIL: "), err)
+		return 1
+	}
+	if _, err := util.NewEngineSchedule(config.Engines); err != nil {
+		fmt.Println(color.Ize(color.Red, "FAIL: "), "invalid engine schedule:", err)
+		return 1
+	}
+	fmt.Println(color.Ize(color.Green, "OK: "), "config is valid")
+	return 0
+}
+
+// runMine runs the miner until it is stopped.
+func runMine(opts mineOptions) {
+	config, err := loadConfig()
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if err := opts.apply(&config); err != nil {
+		log.Fatal("Invalid flags: ", err)
+	}
+	if opts.simulate {
+		verifier, err := util.NewEngineSchedule(config.Engines)
+		if err != nil {
+			log.Fatal("Invalid engine schedule: ", err)
+		}
+		source, err := util.NewLocalWorkSource(verifier, opts.simulateDifficulty, config.Location)
+		if err != nil {
+			log.Fatal("Unable to start the simulated work source: ", err)
+		}
+		defer source.Close()
+		config.GetworkURL = source.URL()
+		log.Println(color.Ize(color.Yellow, "Simulating: "), "mining synthetic work at difficulty", opts.simulateDifficulty, "from", source.URL())
+	}
+	if opts.replay != "" {
+		verifier, err := util.NewEngineSchedule(config.Engines)
+		if err != nil {
+			log.Fatal("Invalid engine schedule: ", err)
+		}
+		events, err := util.LoadRecording(opts.replay)
+		if err != nil {
+			log.Fatal("Unable to load recording: ", err)
+		}
+		source, err := util.NewReplayWorkSource(verifier, events, opts.replaySpeed)
+		if err != nil {
+			log.Fatal("Unable to start the replay work source: ", err)
+		}
+		defer source.Close()
+		config.GetworkURL = source.URL()
+		config.GetworkInterval = replayPollInterval
+		log.Println(color.Ize(color.Yellow, "Replaying: "), len(events), "recorded events from", opts.replay, "at", opts.replaySpeed, "times the recorded pace")
+		go func() {
+			<-source.Done
+			log.Println("Replay finished, stopping miner")
+			exit <- true
+		}()
+	}
+	if err := validateConfig(config); err != nil {
+		log.Fatal("Invalid config: ", err)
+	}
+	loaded := config
+	if len(config.Locations) > 0 {
+		config.Location = config.Locations[0].Location
+	}
+	if !config.Proxy && config.GetworkURL == "" && len(config.Location) == 0 {
+		location, err := util.DetectLocation(config)
+		if err != nil {
+			log.Fatal("Unable to detect the location to mine: ", err)
+		}
+		log.Println("Detected location", location, "from the zone node")
+		config.Location = location
+	}
+	queueSize := resultQueueSize
+	if config.LowMemory {
+		queueSize = lowMemoryQueueSize
+		debug.SetGCPercent(lowMemoryGCPercent)
+		debug.SetMemoryLimit(lowMemoryLimitBytes)
+		log.Println("Low-memory mode enabled, the pprof listener and dashboard stay off")
+	}
+	if config.Guardrails.MaxMemoryMB > 0 {
+		debug.SetMemoryLimit(config.Guardrails.MaxMemoryMB << 20)
+	}
+	// Build the PoW engines for every scheduled algorithm
+	schedule, err := util.NewEngineSchedule(config.Engines)
+	if err != nil {
+		log.Fatal("Invalid engine schedule: ", err)
+	}
+	if len(config.ExcludeCores) > 0 {
+		cores, err := util.ExcludeCores(config.ExcludeCores)
+		if err != nil {
+			log.Fatal("Unable to exclude cores: ", err)
+		}
+		runtime.GOMAXPROCS(cores)
+		schedule.SetThreads(cores)
+		log.Println("Excluded cores", config.ExcludeCores, "mining on", cores, "cores")
+	}
+	if len(config.CPUAffinity) > 0 {
+		cores, err := util.PinCores(config.CPUAffinity)
+		if err != nil {
+			log.Fatal("Unable to pin cores: ", err)
+		}
+		runtime.GOMAXPROCS(cores)
+		schedule.SetThreads(cores)
+		log.Println("Pinned to cores", config.CPUAffinity, "mining on", cores, "cores")
+	}
+	if config.Preset != "" {
+		settings := util.Presets[config.Preset]
+		presetThreads := int(math.Ceil(float64(runtime.GOMAXPROCS(0)) * settings.CoreShare / 100))
+		schedule.SetThreads(presetThreads)
+		if err := util.SetPriority(settings.Nice); err != nil {
+			log.Warnln("Unable to set process priority: ", err)
+		}
+		log.Println("Using", config.Preset, "preset:", presetThreads, "threads at", settings.DutyCycle, "% duty cycle, nice", settings.Nice)
+	}
+	if config.Threads > 0 {
+		schedule.SetThreads(config.Threads)
+		log.Println("Mining with", config.Threads, "threads")
+	}
+	var engine util.PowEngine = schedule
+	if percent := dutyCycle(config); percent > 0 && percent < 100 {
+		engine = util.NewThrottledEngine(schedule, percent)
+		log.Println("Throttling sealing to", percent, "% of each thread's time")
+	}
+	m := &Miner{
+		config:         config,
+		engine:         engine,
+		header:         types.EmptyHeader(),
+		updateCh:       make(chan *types.Header, queueSize),
+		resultCh:       make(chan *types.Header, queueSize),
+		pauseCh:        make(chan bool),
+		previousNumber: [common.HierarchyDepth]uint64{0, 0, 0},
+		rewardAddress:  config.RewardAddressFor(config.Location),
+		start:          time.Now(),
+		quit:           make(chan struct{}),
+		opts:           opts,
+		loaded:         loaded,
+	}
+	m.threadCeiling = int32(cpuCeiling(config.Guardrails))
+	if config.Connectivity == "" {
+		config.Connectivity = defaultConnectivity
+		m.config.Connectivity = defaultConnectivity
+	}
+	profile := util.ConnectivityProfiles[config.Connectivity]
+	m.profile = profile
+	if profile.Spool {
+		spoolFile := config.SpoolFile
+		if spoolFile == "" {
+			spoolFile = defaultSpoolFile
+		}
+		m.spool = util.NewSpool(spoolFile)
+	}
+	if config.Connectivity != defaultConnectivity {
+		log.Println("Using", config.Connectivity, "connectivity profile")
+	}
+	if config.Tracing.Endpoint != "" {
+		instance := config.RigID
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		m.tracer = util.NewTracer(config.Tracing.Endpoint, config.Tracing.Headers, map[string]string{
+			"service.name":        "quai-cpu-miner",
+			"service.version":     USER_AGENT_VER,
+			"service.instance.id": instance,
+		})
+	}
+	if len(config.Tenants) > 0 {
+		m.tenants = util.NewTenants(config.Tenants)
+		m.rewardAddress = m.tenants.Current().Address
+	}
+	if config.SnapshotFile != "" {
+		m.restoreSnapshot()
+	}
+	if len(config.RewardAddresses) > 0 {
+		m.rotator = util.NewAddressRotator(config.RewardAddresses)
+		m.rewardAddress = m.rotator.Next()
+	}
+	log.Println("Starting Quai cpu miner in location ", config.Location)
+	if config.DryRun {
+		log.Warnln("Dry run: solutions are logged, not submitted")
+	}
+	if config.Timestamp.NTPServer != "" {
+		go checkClockSkew(config.Timestamp.NTPServer)
+	}
+	if opts.chaos > 0 {
+		util.EnableChaos(opts.chaos)
+		log.Warnln(color.Ize(color.Red, "Chaos mode: "), "injecting faults with probability", opts.chaos, ", never use this for real mining")
+	}
+	restoreGovernor := func() {}
+	if config.PerformanceGovernor {
+		restore, err := util.SetCPUGovernor("performance")
+		if err != nil {
+			log.Warnln("Unable to set CPU governor to performance: ", err)
+		} else {
+			restoreGovernor = restore
+			log.Println("Set CPU governor to performance, it will be restored on exit")
+		}
+	}
+	if config.EventLog != "" {
+		if err := util.OpenEventLog(config.EventLog); err != nil {
+			log.Fatal("Unable to open event log: ", err)
+		}
+		defer util.CloseEventLog()
+	}
+	if config.HistoryDB != "" {
+		m.history, err = util.OpenHistory(config.HistoryDB)
+		if err != nil {
+			log.Fatal("Unable to open history database: ", err)
+		}
+		defer m.history.Close()
+	}
+	if config.BlocksFile.Path != "" {
+		explorer := config.BlocksFile.ExplorerURL
+		if explorer == "" {
+			explorer = defaultExplorerURL
+		}
+		m.blocks, err = util.OpenBlocksFile(config.BlocksFile.Path, explorer)
+		if err != nil {
+			log.Fatal("Unable to open blocks file: ", err)
+		}
+		defer m.blocks.Close()
+	}
+	if opts.capture != "" {
+		if err := util.StartCapture(opts.capture); err != nil {
+			log.Fatal("Unable to start capture: ", err)
+		}
+		log.Println("Capturing work and submissions to", opts.capture)
+	}
+	if opts.record != "" {
+		if err := util.StartRecording(opts.record); err != nil {
+			log.Fatal("Unable to start recording: ", err)
+		}
+		defer util.StopRecording()
+		log.Println("Recording work and submissions to", opts.record)
+	}
+	if config.PayloadSecret != "" {
+		util.SetPayloadSecret(config.PayloadSecret)
+	}
+	if config.Keystore != "" {
+		m.signer, err = util.LoadSigner(config.Keystore, config.KeystorePassword)
+		if err != nil {
+			log.Fatal("Unable to load keystore: ", err)
+		}
+		log.Println("Signing proxy requests with key", m.signer.Address().Hex())
+	}
+	if config.DevFee.Percent > 0 {
+		if config.Proxy {
+			log.Println(color.Ize(color.Purple, "Dev fee enabled: "), fmt.Sprintf("mining %g%% of the time to %s", config.DevFee.Percent, config.DevFee.Address))
+		} else {
+			log.Warnln("Dev fee is only supported when mining through a proxy, disabling it")
+			config.DevFee.Percent = 0
+			m.config.DevFee.Percent = 0
+		}
+	}
+	if config.Proxy {
+		m.proxyClient, m.proxyIndex = connectToProxy(config, m.profile, 0)
+		m.connected = 1
+		if config.ProxyWorkTimeout > 0 {
+			go m.proxyWorkWatchdog()
+		}
+		if m.profile.PingInterval > 0 {
+			go m.proxyPingLoop()
+		}
+		if opts.chaos > 0 {
+			go m.chaosDropLoop()
+		}
+		go m.fetchPendingHeaderProxy()
+		go m.startProxyListener()
+		go func() {
+			m.subscribeProxy()
+			m.flushSpool()
+		}()
+		if config.DevFee.Percent > 0 {
+			go m.devFeeLoop()
+		}
+		if config.HashrateInterval > 0 {
+			go m.hashrateReportLoop()
+		}
+		if m.tenants != nil {
+			go m.tenantLoop()
+		}
+	} else if config.GetworkURL != "" {
+		m.getworkClient = util.NewGetworkClient(config.GetworkURL)
+		go m.pollGetwork()
+	} else {
+		if config.Discovery.Enabled() {
+			util.DiscoverNodes(&config)
+			m.config = config
+		}
+		m.sliceClients = connectToSlice(config)
+		m.connected = 1
+		m.checkNodeLocation()
+		m.checkNetwork()
+		m.waitForSync()
+		if config.TestDifficulty > 0 {
+			m.checkTestNetwork()
+		}
+		go m.fetchPendingHeaderNode()
+		// No separate call needed to start listeners.
+		go m.subscribeNode()
+		if config.TipCheckInterval > 0 {
+			m.staleCh = make(chan common.Hash)
+			go m.tipCheckLoop()
+		}
+		if len(config.Locations) > 1 {
+			threads := m.splitThreads(m.engine.Threads())
+			m.engine.SetThreads(threads[0])
+			log.Println("Mining location", config.Location, "with", threads[0], "threads")
+			for i, zone := range config.Locations[1:] {
+				m.zones = append(m.zones, m.startZoneMiner(zone.Location, threads[i+1]))
+			}
+		}
+	}
+	go m.resultLoop()
+	if config.Listeners.Getwork.Enabled() {
+		go m.bridgeLoop()
+	} else {
+		go m.miningLoop()
+	}
+	go m.hashratePrinter()
+	if opts.tui && config.LowMemory {
+		log.Warnln("The terminal dashboard is disabled in low-memory mode")
+	} else if opts.tui {
+		m.dashboard = util.NewDashboard(os.Stdout)
+		log.SetOutput(m.dashboard)
+		go m.dashboardLoop()
+	}
+	if config.Listeners.Control.Enabled() {
+		go m.startControlServer()
+	}
+	if config.Listeners.SNMP.Enabled() {
+		go m.startSNMPAgent()
+	}
+	if config.Listeners.Metrics.Enabled() {
+		go m.startMetricsServer()
+	}
+	if config.Listeners.Status.Enabled() {
+		go m.startStatusServer()
+	}
+	if config.Listeners.Pprof.Enabled() && config.LowMemory {
+		log.Warnln("The pprof listener is disabled in low-memory mode")
+	} else if config.Listeners.Pprof.Enabled() {
+		go m.startPprofServer()
+	}
+	if config.Policy.Script != "" {
+		m.policy, err = util.LoadPolicy(config.Policy.Script)
+		if err != nil {
+			log.Fatal("Unable to load policy script: ", err)
+		}
+		go m.policyLoop()
+	}
+	if config.Cooling.HighTemp > 0 {
+		go m.coolingLoop()
+	}
+	if config.Guardrails.MaxCPUPercent > 0 || config.Guardrails.MaxLoad > 0 {
+		go m.guardrailLoop()
+	}
+	if config.Idle.After > 0 {
+		go m.idleLoop()
+	}
+	if config.AutoTune.Enabled {
+		go m.autoTuneLoop()
+	}
+	if config.Telemetry.Enabled && config.Telemetry.URL != "" {
+		go m.telemetryLoop()
+	}
+	if config.Influx.URL != "" {
+		go m.influxLoop()
+	}
+	if m.tracer != nil {
+		go m.tracingLoop()
+	}
+	if config.StatsFile.Path != "" {
+		if m.stats, err = util.OpenStatsFile(config.StatsFile.Path, config.StatsFile.Format); err != nil {
+			log.Warnln("Unable to open stats file: ", err)
+		} else {
+			go m.statsFileLoop()
+		}
+	}
+	if config.BalanceInterval > 0 {
+		go m.balanceLoop()
+	}
+	go handleSignals()
+	go m.reloadOnHangup()
+	go m.fireHook(config.Hooks.OnStart, hookEvent{Event: "on_start"})
+	if err := util.SdNotify("READY=1"); err != nil {
+		log.Warnln("Unable to notify systemd: ", err)
+	}
+	if interval := util.WatchdogInterval(); interval > 0 {
+		go m.watchdogLoop(interval)
+	}
+	<-exit
+	util.SdNotify("STOPPING=1")
+	if m.dashboard != nil {
+		log.SetOutput(os.Stderr)
+	}
+	m.shutdown()
+	if err := m.tracer.Flush(true); err != nil {
+		log.Warnln("Unable to export traces: ", err)
+	}
+	restoreGovernor()
+	if config.SnapshotFile != "" {
+		if err := m.Snapshot(); err != nil {
+			log.Errorln("Unable to write snapshot: ", err)
+		}
+	}
+	if m.stats != nil {
+		m.writeStats(time.Now())
+		m.stats.Close()
+	}
+	if err := util.FinishCapture(config, USER_AGENT_VER); err != nil {
+		log.Errorln("Unable to write capture bundle: ", err)
+	}
+	m.fireHook(config.Hooks.OnShutdown, hookEvent{Event: "on_shutdown"})
+}
+
+// runService installs, uninstalls, starts or stops the Windows service. The
+// service mines with the config file in use when it was installed.
+func runService(action string) int {
+	var args []string
+	if action == "install" {
+		path := configPath
+		if path == "" {
+			path = "config/config.yaml"
+			if _, err := os.Stat(path); err != nil {
+				path = "config.yaml"
+			}
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			fmt.Println("Unable to resolve the config file:", err)
+			return 1
+		}
+		args = []string{"mine", "--config", path}
+	}
+	if err := util.ControlService(action, args); err != nil {
+		fmt.Println(color.Ize(color.Red, "FAIL: "), "service", action, "failed:", err)
+		return 1
+	}
+	fmt.Println(color.Ize(color.Green, "OK: "), "service", action)
+	return 0
+}
+
+// runVerify re-verifies a recorded work item and claimed solution:
+//
+//	quai-cpu-miner verify <header.json> [nonce] [mixHash]
+//
+// The nonce and mix hash default to the ones in the header file.
+func runVerify(args []string) int {
+	if len(args) < 1 {
+		fmt.Println("usage: quai-cpu-miner verify <header.json> [nonce] [mixHash]")
+		return 2
+	}
+	header, err := util.LoadHeader(args[0])
+	if err != nil {
+		fmt.Println("Unable to load header:", err)
+		return 1
+	}
+	if len(args) > 1 {
+		nonce, err := strconv.ParseUint(args[1], 0, 64)
+		if err != nil {
+			fmt.Println("Invalid nonce:", err)
+			return 1
+		}
+		header.SetNonce(types.EncodeNonce(nonce))
+	}
+	if len(args) > 2 {
+		mixHash := common.HexToHash(args[2])
+		header.SetMixHash(&mixHash)
+	}
+	engine, err := util.NewEngineSchedule(nil)
+	if err != nil {
+		fmt.Println("Unable to build engine:", err)
+		return 1
+	}
+	report := util.VerifySolution(engine, header)
+	fmt.Println("Number:      ", header.NumberArray())
+	fmt.Println("Seal hash:   ", report.SealHash.Hex())
+	fmt.Println("Nonce:       ", header.NonceU64())
+	fmt.Println("PoW hash:    ", report.PowHash.Hex())
+	fmt.Println("Mix hash:    ", report.MixHash.Hex(), "claimed", report.ClaimedMixHash.Hex())
+	fmt.Println("Target:      ", fmt.Sprintf("%#064x", report.Target))
+	fmt.Println("Meets target:", report.MeetsTarget)
+	if report.Err != nil {
+		fmt.Println(color.Ize(color.Red, "INVALID: "), report.Err)
+		return 1
+	}
+	fmt.Println(color.Ize(color.Blue, "VALID, order: "), report.Order)
+	return 0
+}
+
+// runSelfTest runs the whole pipeline, from fetching work to submitting the
+// solution, against an in-process work source at a trivial difficulty:
+//
+//	quai-cpu-miner selftest [timeoutSeconds]
+//
+// It passes when a valid solution is submitted within the timeout.
+func runSelfTest(args []string) int {
+	timeout := defaultSelfTestTimeout
+	if len(args) > 0 {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil || seconds <= 0 {
+			fmt.Println("usage: quai-cpu-miner selftest [timeoutSeconds]")
+			return 2
+		}
+		timeout = seconds
+	}
+	engine, err := util.NewEngineSchedule(nil)
+	if err != nil {
+		fmt.Println("Unable to build engine:", err)
+		return 1
+	}
+	config := util.Config{Location: common.Location{0, 0}, GetworkInterval: selfTestPollInterval}
+	source, err := util.NewLocalWorkSource(engine, selfTestDifficulty, config.Location)
+	if err != nil {
+		fmt.Println("Unable to start local work source:", err)
+		return 1
+	}
+	defer source.Close()
+	config.GetworkURL = source.URL()
+	m := &Miner{
+		config:        config,
+		engine:        engine,
+		header:        types.EmptyHeader(),
+		getworkClient: util.NewGetworkClient(config.GetworkURL),
+		updateCh:      make(chan *types.Header, resultQueueSize),
+		resultCh:      make(chan *types.Header, resultQueueSize),
+		pauseCh:       make(chan bool),
+	}
+	fmt.Println("Self test: mining at difficulty", selfTestDifficulty, "on", runtime.GOMAXPROCS(0), "cores, timeout", timeout, "seconds")
+	start := time.Now()
+	go m.pollGetwork()
+	go m.resultLoop()
+	go m.miningLoop()
+	select {
+	case report := <-source.Solutions:
+		if report.Err != nil || !report.MeetsTarget {
+			fmt.Println(color.Ize(color.Red, "FAIL: "), "submitted solution is invalid:", report.Err)
+			return 1
+		}
+		fmt.Println(color.Ize(color.Green, "PASS: "), "valid solution submitted after", time.Since(start).Round(time.Millisecond), "pow hash", report.PowHash.Hex())
+		return 0
+	case <-time.After(time.Duration(timeout) * time.Second):
+		fmt.Println(color.Ize(color.Red, "FAIL: "), "no solution submitted within", timeout, "seconds, hashrate", m.engine.Hashrate())
+		return 1
+	}
+}
+
+// runBench measures the hashrate at every thread count from 1 to maxThreads:
+//
+//	quai-cpu-miner bench [secondsPerStep] [maxThreads]
+//
+// and recommends the fewest threads that get within 5% of the best result.
+// Results are stored in the results database and every thread count more than
+// 10% slower than in the previous run on the same machine is flagged.
+func runBench(args []string, resultsPath string) int {
+	usage := "usage: quai-cpu-miner bench [secondsPerStep] [maxThreads]"
+	seconds, maxThreads := defaultBenchDuration, runtime.GOMAXPROCS(0)
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			fmt.Println(usage)
+			return 2
+		}
+		seconds = n
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			fmt.Println(usage)
+			return 2
+		}
+		maxThreads = n
+	}
+	engine, err := util.NewEngineSchedule(nil)
+	if err != nil {
+		fmt.Println("Unable to build engine:", err)
+		return 1
+	}
+	fmt.Println("Benchmarking 1 to", maxThreads, "threads,", seconds, "seconds each")
+	start := time.Now()
+	rates := make([]float64, maxThreads+1)
+	best := 0.0
+	for threads := 1; threads <= maxThreads; threads++ {
+		rates[threads] = util.Benchmark(engine, threads, time.Duration(seconds)*time.Second)
+		best = math.Max(best, rates[threads])
+		fmt.Printf("%3d threads: %10.2f h/s (%.2f h/s per thread)\n", threads, rates[threads], rates[threads]/float64(threads))
+	}
+	for threads := 1; threads <= maxThreads; threads++ {
+		if rates[threads] >= best*benchRecommendShare {
+			fmt.Printf("Recommended: Threads: %d (%.2f h/s)\n", threads, rates[threads])
+			break
+		}
+	}
+	if resultsPath != "" {
+		run := util.BenchRun{
+			Time:        start,
+			Fingerprint: util.HardwareFingerprint(),
+			Backend:     minerBackend,
+			Version:     USER_AGENT_VER,
+			Hashrates:   make(map[int]float64, maxThreads),
+		}
+		for threads := 1; threads <= maxThreads; threads++ {
+			run.Hashrates[threads] = rates[threads]
+		}
+		if err := compareBench(resultsPath, run); err != nil {
+			fmt.Println("Unable to keep bench results:", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// compareBench flags the thread counts that got slower since the previous run
+// on the same machine and stores run.
+func compareBench(path string, run util.BenchRun) error {
+	db, err := util.OpenBenchDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	previous, err := db.Previous(run.Fingerprint, run.Backend)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		regressions := 0
+		for threads := 1; threads <= len(run.Hashrates); threads++ {
+			before, ok := previous.Hashrates[threads]
+			if !ok || run.Hashrates[threads] >= before*benchRegressionShare {
+				continue
+			}
+			regressions++
+			fmt.Printf("%s %d threads: %.2f h/s, was %.2f h/s (%+.1f%%)\n", color.Ize(color.Red, "REGRESSION:"), threads, run.Hashrates[threads], before, (run.Hashrates[threads]/before-1)*100)
+		}
+		if regressions == 0 {
+			fmt.Println("No regression against the run of", previous.Time.Format(time.RFC3339), "with version", previous.Version)
+		} else {
+			fmt.Println(regressions, "thread counts slower than the run of", previous.Time.Format(time.RFC3339), "with version", previous.Version)
+		}
+	}
+	return db.Record(run)
+}
+
+// runProbeProxy checks the configured proxy against the protocol the miner
+// expects and reports each check:
+//
+//	quai-cpu-miner probe-proxy [pushWaitSeconds]
+//
+// The push wait defaults to 60 seconds, 0 skips the pushed work check.
+func runProbeProxy(config util.Config, args []string) int {
+	pushWait := defaultProbePushWait
+	if len(args) > 0 {
+		seconds, err := strconv.Atoi(args[0])
+		if err != nil || seconds < 0 {
+			fmt.Println("usage: quai-cpu-miner probe-proxy [pushWaitSeconds]")
+			return 2
+		}
+		pushWait = seconds
+	}
+	var signer *util.Signer
+	if config.Keystore != "" {
+		var err error
+		signer, err = util.LoadSigner(config.Keystore, config.KeystorePassword)
+		if err != nil {
+			fmt.Println("Unable to load keystore:", err)
+			return 1
+		}
+	}
+	address := config.RewardAddressFor(config.Location)
+	if len(config.RewardAddresses) > 0 {
+		address = config.RewardAddresses[0].Address
+	}
+	params, err := proxyLoginParams(address, config.Password, signer)
+	if err != nil {
+		fmt.Println("Unable to sign login request:", err)
+		return 1
+	}
+	profile, ok := util.ConnectivityProfiles[config.Connectivity]
+	if !ok {
+		profile = util.ConnectivityProfiles[defaultConnectivity]
+	}
+	session, _ := connectToProxy(config, profile, 0)
+	fmt.Println("Probing proxy", config.ProxyURL, "using the", session.Dialect(), "dialect")
+	failed := false
+	for _, result := range util.ProbeProxy(session, params, time.Duration(pushWait)*time.Second) {
+		status := string(result.Status)
+		switch result.Status {
+		case util.ProbePass:
+			status = color.Ize(color.Green, status)
+		case util.ProbeWarn:
+			status = color.Ize(color.Yellow, status)
+		case util.ProbeFail:
+			status = color.Ize(color.Red, status)
+			failed = true
+		}
+		fmt.Printf("%s  %-26s %s\n", status, result.Check, result.Detail)
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkTestNetwork refuses to run with a test difficulty unless the zone node
+// is on the local development network, so artificially easy solutions can
+// never be submitted to a public chain.
+func (m *Miner) checkTestNetwork() {
+	chainID, err := m.sliceClients[common.ZONE_CTX].ChainID(context.Background())
+	if err != nil {
+		log.Fatal("Unable to verify chain id for TestDifficulty: ", err)
+	}
+	if chainID.Cmp(params.LocalChainConfig.ChainID) != 0 {
+		log.Fatal("Refusing TestDifficulty on chain id ", chainID, ", it is only allowed on the local network (", params.LocalChainConfig.ChainID, ")")
+	}
+	log.Println(color.Ize(color.Yellow, "TEST ONLY: sealing against local difficulty "), m.config.TestDifficulty, "instead of the node's target, the node will reject these blocks")
+}
+
+// watchdogLoop pings the systemd watchdog while work keeps arriving, so a
+// hung connection gets the miner restarted instead of looking healthy.
+func (m *Miner) watchdogLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	healthy := true
+	for {
+		select {
+		case <-ticker.C:
+			last := time.Unix(0, atomic.LoadInt64(&m.lastWork))
+			if last.Before(m.start) {
+				last = m.start
+			}
+			if time.Since(last) > watchdogWorkAge {
+				if healthy {
+					log.Warnln("No work for", watchdogWorkAge, "withholding the systemd watchdog ping")
+				}
+				healthy = false
+				continue
+			}
+			healthy = true
+			if err := util.SdNotify("WATCHDOG=1"); err != nil {
+				log.Warnln("Unable to ping the systemd watchdog: ", err)
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// checkNodeLocation stops the miner when the zone node runs a different
+// location than the one configured for its URL.
+func (m *Miner) checkNodeLocation() {
+	loc := m.config.Location
+	url := m.config.ZoneURLs[loc.Region()][loc.Zone()]
+	location, err := util.NodeLocation(url, outboundDialer(m.config))
+	if err != nil {
+		log.Warnln("Unable to verify the location of zone node", url, ":", err)
+		return
+	}
+	if !location.Equal(loc) {
+		log.Fatal("Zone node at ", url, " runs location ", location, " but the miner is configured for ", loc, ", fix Location or --region/--zone")
+	}
+}
+
+// checkNetwork stops the miner when the zone node runs a different chain than
+// the configured Network.
+func (m *Miner) checkNetwork() {
+	if m.config.Network == "" {
+		return
+	}
+	want := util.Networks[m.config.Network].ChainID
+	chainID, err := m.sliceClients[common.ZONE_CTX].ChainID(context.Background())
+	if err != nil {
+		log.Warnln("Unable to verify the zone node runs", m.config.Network, ":", err)
+		return
+	}
+	if chainID.Cmp(want) != 0 {
+		log.Fatal("Zone node runs chain id ", chainID, " but the miner is configured for ", m.config.Network, " (", want, "), fix Network or --network")
+	}
+}
+
+// waitForSync holds off mining until every node of the slice has synced and,
+// with MaxHeadAge, has a recent chain head, logging their progress. Without
+// Wait it only warns, since work from a node still syncing ends up orphaned.
+func (m *Miner) waitForSync() {
+	for {
+		lagging := m.laggingNodes()
+		if len(lagging) == 0 {
+			return
+		}
+		if !m.config.SyncCheck.Wait {
+			log.Warnln("Mining on nodes that haven't synced, their work will likely be orphaned:", strings.Join(lagging, ", "))
+			return
+		}
+		log.Println("Waiting for the nodes to sync before mining:", strings.Join(lagging, ", "))
+		select {
+		case <-time.After(syncCheckInterval):
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// laggingNodes describes the slice's nodes that are still syncing or whose
+// chain head is older than MaxHeadAge. Nodes that can't say are trusted.
+func (m *Miner) laggingNodes() []string {
+	maxHeadAge := time.Duration(m.config.SyncCheck.MaxHeadAge) * time.Second
+	var lagging []string
+	for ctx, client := range m.sliceClients {
+		if client == nil {
+			continue
+		}
+		name := util.ContextName(ctx)
+		c, cancel := context.WithTimeout(context.Background(), syncCheckTimeout)
+		progress, err := client.SyncProgress(c)
+		if err != nil {
+			log.Debugln("Unable to read the sync status of the", name, "node: ", err)
+		} else if progress != nil && progress.CurrentBlock < progress.HighestBlock {
+			lagging = append(lagging, fmt.Sprintf("%s at block %d of %d", name, progress.CurrentBlock, progress.HighestBlock))
+			cancel()
+			continue
+		}
+		if maxHeadAge > 0 {
+			head, err := client.HeaderByNumber(c, nil)
+			if err != nil {
+				log.Debugln("Unable to read the chain head of the", name, "node: ", err)
+			} else if age := time.Since(time.Unix(int64(head.Time()), 0)); age > maxHeadAge {
+				lagging = append(lagging, fmt.Sprintf("%s head %d is %s old", name, head.NumberU64(ctx), age.Round(time.Second)))
+			}
+		}
+		cancel()
+	}
+	return lagging
+}
+
+// reloadOnHangup reloads the config file on SIGHUP.
+func (m *Miner) reloadOnHangup() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+	for {
+		select {
+		case <-sigCh:
+			if err := m.Reload(); err != nil {
+				log.Warnln("Unable to reload config: ", err)
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// handleSignals stops the miner on SIGINT or SIGTERM.
+func handleSignals() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigCh
+	log.Println("Received signal", sig, "stopping miner")
+	exit <- true
+	sig = <-sigCh
+	log.Println("Received signal", sig, "again, exiting without finishing shutdown")
+	os.Exit(1)
+}
+
+// shutdown stops sealing, waits for the solutions already found to be
+// submitted, closes the work source connections and prints a session summary.
+func (m *Miner) shutdown() {
+	for _, z := range m.zones {
+		z.shutdown()
+	}
+	atomic.StoreInt32(&m.stopping, 1)
+	close(m.quit)
+	drained := make(chan struct{})
+	go func() {
+		for len(m.resultCh) > 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		m.submissions.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(shutdownTimeout):
+		log.Warnln("Gave up waiting for in-flight submissions after", shutdownTimeout)
+	}
+	if m.proxyClient != nil {
+		m.proxy().Close()
+	}
+	for _, client := range m.sliceClients {
+		if client != nil {
+			client.Close()
+		}
+	}
+	m.sampleHashrate(m.engine.Hashrate())
+	hashrates := m.hashrates.Summary()
+	found := m.foundBlocks()
+	log.Println("Session summary for location", m.config.Location, "uptime", time.Since(m.start).Round(time.Second), fmt.Sprintf("average hashrate %.2f h/s, peak %.2f h/s,", hashrates.Average, hashrates.Peak), "blocks found prime", found[common.PRIME_CTX], "region", found[common.REGION_CTX], "zone", found[common.ZONE_CTX])
+}
+
+// sampleHashrate adds a hashrate reading to the session trend.
+func (m *Miner) sampleHashrate(hashrate float64) {
+	m.hashrates.Add(hashrate, time.Now())
+}
+
+// subscribeProxy subscribes to the head of the mining nodes in order to pass
+// the most up to date block to the miner within the manager.
+func (m *Miner) subscribeProxy() error {
+	address := m.currentRewardAddress()
+	password := m.config.Password
+
+	params, err := proxyLoginParams(address, password, m.signer)
+	if err != nil {
+		log.Fatalf("Unable to sign login request: %v", err)
+	}
+	return m.proxy().Send("quai_submitLogin", params...)
+}
+
+// proxyLoginParams builds the quai_submitLogin parameters.
+func proxyLoginParams(address, password string, signer *util.Signer) ([]interface{}, error) {
+	params := []interface{}{address, password}
+	if signer != nil {
+		// Rigs with a keystore key prove ownership instead of relying on the password alone.
+		timestamp := time.Now().Unix()
+		signature, err := signer.SignLogin(address, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, timestamp, signature)
+	}
+	return params, nil
+}
+
+// currentRewardAddress returns the address the proxy session mines to.
+func (m *Miner) currentRewardAddress() string {
+	m.rewardLock.Lock()
+	defer m.rewardLock.Unlock()
+	if m.devFeeActive {
+		return m.config.DevFee.Address
+	}
+	return m.rewardAddress
+}
+
+// rotateRewardAddress moves to the next address in the rotation and logs the
+// proxy session in with it.
+func (m *Miner) rotateRewardAddress() {
+	m.setRewardAddress(m.rotator.Next())
+}
+
+// tenantLoop switches the proxy session between the tenants' addresses.
+func (m *Miner) tenantLoop() {
+	interval := m.config.TenantInterval
+	if interval <= 0 {
+		interval = defaultTenantInterval
+	}
+	log.Println("Mining for", m.tenants.Current().Name, "switching tenants every", interval, "seconds")
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			tenant := m.tenants.Next()
+			log.Println("Mining for tenant", tenant.Name)
+			m.setRewardAddress(tenant.Address)
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// setRewardAddress changes the reward address and logs the proxy session in
+// with it.
+func (m *Miner) setRewardAddress(address string) {
+	m.rewardLock.Lock()
+	changed := address != m.rewardAddress
+	m.rewardAddress = address
+	donating := m.devFeeActive
+	m.rewardLock.Unlock()
+	if !changed || donating {
+		// The rotated address is logged in once the dev fee window ends.
+		return
+	}
+	log.Println("Switching reward address to", address)
+	if err := m.subscribeProxy(); err != nil {
+		log.Warnln("Unable to log in with new reward address: ", err)
+	}
+}
+
+// devFeeLoop mines to the dev fee address for DevFee.Percent of every
+// period, switching the proxy session's reward address at the window edges.
+func (m *Miner) devFeeLoop() {
+	period := time.Duration(m.config.DevFee.Period) * time.Second
+	if period <= 0 {
+		period = defaultDevFeePeriod * time.Second
+	}
+	window := time.Duration(float64(period) * m.config.DevFee.Percent / 100)
+	setActive := func(active bool) {
+		m.rewardLock.Lock()
+		m.devFeeActive = active
+		m.rewardLock.Unlock()
+		if err := m.subscribeProxy(); err != nil {
+			log.Warnln("Unable to switch reward address for the dev fee: ", err)
+		}
+	}
+	for {
+		time.Sleep(period - window)
+		log.Println(color.Ize(color.Purple, "Dev fee: "), "mining to", m.config.DevFee.Address, "for", window.Round(time.Second))
+		setActive(true)
+		time.Sleep(window)
+		atomic.AddInt64(&m.devFeeTime, int64(window))
+		setActive(false)
+		log.Println(color.Ize(color.Purple, "Dev fee: "), "window over, mining to", m.currentRewardAddress())
+	}
+}
+
+func (m *Miner) startProxyListener() {
+	for {
+		err := m.proxy().ListenTCP(m.updateCh)
+		atomic.StoreInt32(&m.connected, 0)
+		if atomic.LoadInt32(&m.stopping) == 1 {
+			return
+		}
+		reason := "proxy closed the connection"
+		if err != nil {
+			reason = err.Error()
+		}
+		m.fireHook(m.config.Hooks.OnDisconnect, hookEvent{Event: "on_disconnect", Reason: reason})
+		if !m.profile.Reconnect && len(m.config.ProxyList()) < 2 {
+			return
+		}
+		log.Warnln("Proxy connection lost, reconnecting: ", reason)
+		m.reconnectProxy()
+	}
+}
+
+// proxyWorkWatchdog drops the proxy connection when it sends no work for
+// ProxyWorkTimeout seconds, so the listener fails over to the next proxy.
+func (m *Miner) proxyWorkWatchdog() {
+	timeout := time.Duration(m.config.ProxyWorkTimeout) * time.Second
+	atomic.StoreInt64(&m.lastWork, time.Now().UnixNano())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&m.connected) == 0 {
+				continue
+			}
+			if time.Since(time.Unix(0, atomic.LoadInt64(&m.lastWork))) > timeout {
+				log.Warnln("No work from the proxy for", timeout, "dropping the connection")
+				atomic.StoreInt64(&m.lastWork, time.Now().UnixNano())
+				m.proxy().Close()
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// chaosDropLoop closes the proxy connection at random for --chaos, leaving
+// the listener to reconnect.
+func (m *Miner) chaosDropLoop() {
+	ticker := time.NewTicker(chaosDropInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&m.connected) == 1 && util.ChaosFault(util.ChaosDrop) {
+				m.proxy().Close()
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// proxyPingLoop asks the proxy for work every PingInterval and drops the
+// session when no reply comes back, so a half-open connection is redialled
+// instead of leaving the miner on stale work.
+func (m *Miner) proxyPingLoop() {
+	ticker := time.NewTicker(m.profile.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if atomic.LoadInt32(&m.connected) == 0 {
+				continue
+			}
+			session := m.proxy()
+			// The reply carries work, which the work queue drops if unchanged.
+			call, err := session.Call("quai_getPendingHeader", nil)
+			if err == nil {
+				resp, waitErr := call.Wait(proxyPingTimeout)
+				if resp != nil {
+					// Any answer, even an error, shows the proxy is there.
+					continue
+				}
+				err = waitErr
+			}
+			log.Warnln("Proxy did not answer the keepalive ping, dropping the connection: ", err)
+			session.Close()
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// proxy returns the current proxy session.
+func (m *Miner) proxy() *util.MinerSession {
+	m.proxyLock.RLock()
+	defer m.proxyLock.RUnlock()
+	return m.proxyClient
+}
+
+// reconnectProxy dials the proxy again, logs in, asks for fresh work and
+// resends spooled submissions.
+func (m *Miner) reconnectProxy() {
+	time.Sleep(m.profile.ReconnectDelay)
+	m.proxyLock.RLock()
+	next := m.proxyIndex
+	m.proxyLock.RUnlock()
+	if len(m.config.ProxyList()) > 1 {
+		// Fail over to the next proxy in priority order.
+		next++
+	}
+	client, index := connectToProxy(m.config, m.profile, next)
+	atomic.AddUint64(&m.reconnects, 1)
+	atomic.StoreInt64(&m.lastWork, time.Now().UnixNano())
+	m.proxyLock.Lock()
+	m.proxyClient = client
+	m.proxyIndex = index
+	m.proxyLock.Unlock()
+	atomic.StoreInt32(&m.connected, 1)
+	if err := m.subscribeProxy(); err != nil {
+		log.Warnln("Unable to log in after reconnecting: ", err)
+	}
+	if err := client.Send("quai_getPendingHeader", nil); err != nil {
+		log.Warnln("Unable to request work after reconnecting: ", err)
+	}
+	go m.flushSpool()
+}
+
+// flushSpool resends submissions spooled while the proxy was unreachable.
+func (m *Miner) flushSpool() {
+	if m.spool == nil {
+		return
+	}
+	entries, err := m.spool.Drain()
+	if err != nil {
+		log.Warnln("Unable to read submission spool: ", err)
+		return
+	}
+	for _, params := range entries {
+		if err := m.proxy().Send("quai_receiveMinedHeader", params...); err != nil {
+			log.Warnln("Unable to resend spooled submission, keeping it: ", err)
+			m.spool.Add(params)
+			continue
+		}
+		log.Println("Resent spooled submission")
+	}
+}
+
+// Subscribes to the zone node in order to get pending header updates,
+// resubscribing with backoff and refetching the pending header whenever the
+// subscription drops.
+func (m *Miner) subscribeNode() {
+	backoff := m.profile.RetryBackoff()
+	lost := false
+	for {
+		sub, err := m.sliceClients[common.ZONE_CTX].SubscribePendingHeader(context.Background(), m.updateCh)
+		if err != nil {
+			log.Warnln("Failed to subscribe to pending header events: ", err)
+			if !backoff.Wait(m.quit) {
+				return
+			}
+			continue
+		}
+		backoff.Reset()
+		if lost {
+			// Headers pushed while the subscription was down are gone, so
+			// catch up on the latest one.
+			log.Println("Resubscribed to pending header events")
+			atomic.StoreInt32(&m.connected, 1)
+			go m.fetchPendingHeaderNode()
+		}
+		select {
+		case err := <-sub.Err():
+			if atomic.LoadInt32(&m.stopping) == 1 {
+				return
+			}
+			lost = true
+			atomic.StoreInt32(&m.connected, 0)
+			atomic.AddUint64(&m.reconnects, 1)
+			reason := "subscription closed"
+			if err != nil {
+				reason = err.Error()
+			}
+			log.Warnln("Pending header subscription lost, resubscribing: ", reason)
+			go m.fireHook(m.config.Hooks.OnDisconnect, hookEvent{Event: "on_disconnect", Reason: reason})
+		case <-m.quit:
+			sub.Unsubscribe()
+			return
+		}
+	}
+}
+
+// Gets the latest pending header from the proxy.
+// This only runs upon initialization, further proxy pending headers are received in listenTCP.
+func (m *Miner) fetchPendingHeaderProxy() {
+	backoff := m.profile.RetryBackoff()
+	for {
+		// The reply reaches the work queue through the listener.
+		err := m.proxy().Send("quai_getPendingHeader", nil)
+		if err != nil {
+			log.Warnln("Pending block not found error: ", err)
+			time.Sleep(backoff.Next())
+		} else {
+			break
+		}
+	}
+}
+
+// pollGetwork polls the getwork endpoint and pushes work whenever the sealhash
+// changes.
+func (m *Miner) pollGetwork() {
+	interval := m.config.GetworkInterval
+	if interval <= 0 {
+		interval = defaultGetworkInterval
+	}
+	log.Println("Polling getwork endpoint", m.config.GetworkURL, "every", interval, "ms")
+	ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+	var (
+		sealHash common.Hash
+		lost     bool
+	)
+	for {
+		select {
+		case <-ticker.C:
+			header, err := m.getworkClient.GetWork()
+			if err != nil {
+				if atomic.SwapInt32(&m.connected, 0) == 1 {
+					lost = true
+					go m.fireHook(m.config.Hooks.OnDisconnect, hookEvent{Event: "on_disconnect", Reason: err.Error()})
+				}
+				log.Warnln("Unable to fetch getwork: ", err)
+				continue
+			}
+			if atomic.SwapInt32(&m.connected, 1) == 0 && lost {
+				lost = false
+				atomic.AddUint64(&m.reconnects, 1)
+			}
+			if header.SealHash() != sealHash {
+				sealHash = header.SealHash()
+				m.updateCh <- header
+			}
+		}
+	}
+}
+
+// Gets the latest pending header from the zone client.
+func (m *Miner) fetchPendingHeaderNode() {
+	backoff := m.profile.RetryBackoff()
+	for {
+		header, err := m.sliceClients[common.ZONE_CTX].GetPendingHeader(context.Background())
+		if err != nil {
+			log.Warnln("Pending block not found error: ", err)
+			time.Sleep(backoff.Next())
+		} else {
+			m.updateCh <- header
+			break
+		}
+	}
+}
+
+// refetchWork asks the work source for fresh work after the current work went
+// stale. The getwork poller pushes new work by itself.
+func (m *Miner) refetchWork() {
+	if m.config.Proxy {
+		if err := m.proxy().Send("quai_getPendingHeader", nil); err != nil {
+			log.Warnln("Unable to request fresh work: ", err)
+		}
+	} else if m.getworkClient == nil {
+		go m.fetchPendingHeaderNode()
+	}
+}
+
+// tipCheckLoop checks every TipCheckInterval seconds that the parent of the
+// work being sealed is still the zone chain tip, so work orphaned by a reorg
+// is abandoned.
+func (m *Miner) tipCheckLoop() {
+	ticker := time.NewTicker(time.Duration(m.config.TipCheckInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+		m.statsLock.Lock()
+		number, parent := m.workNumber[common.ZONE_CTX], m.workParent
+		m.statsLock.Unlock()
+		if number == 0 {
+			continue
+		}
+		tip, err := m.sliceClients[common.ZONE_CTX].HeaderByNumber(context.Background(), nil)
+		if err != nil {
+			log.Debugln("Unable to fetch the zone chain tip: ", err)
+			continue
+		}
+		// A tip behind the work's parent just means the node is catching up.
+		if tip.NumberU64(common.ZONE_CTX)+1 < number || tip.Hash() == parent {
+			continue
+		}
+		select {
+		case m.staleCh <- parent:
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// bridgeLoop hands the node's work to external miners over the getwork
+// bridge instead of sealing it. Their solutions go through resultLoop like the
+// miner's own.
+func (m *Miner) bridgeLoop() {
+	bridge := util.NewGetworkBridge(m.engine, func(header *types.Header) {
+		m.resultCh <- header
+	})
+	go func() {
+		if err := m.config.Listeners.Getwork.Serve("getwork bridge", bridge); err != nil {
+			log.Warnln("Getwork bridge stopped: ", err)
+		}
+	}()
+	log.Println("Bridging work to getwork miners, not sealing")
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case now := <-heartbeat.C:
+			atomic.StoreInt64(&m.heartbeat, now.UnixNano())
+		case header := <-m.updateCh:
+			atomic.StoreInt64(&m.lastWork, time.Now().UnixNano())
+			util.LogEvent(util.EventWork, util.EventFields{"number": header.NumberArray(), "sealHash": header.SealHash(), "difficulty": header.Difficulty()})
+			m.statsLock.Lock()
+			m.workNumber = [common.HierarchyDepth]uint64{header.NumberU64(common.PRIME_CTX), header.NumberU64(common.REGION_CTX), header.NumberU64(common.ZONE_CTX)}
+			m.workLocation = header.Location()
+			m.workParent = header.ParentHash(common.ZONE_CTX)
+			m.statsLock.Unlock()
+			bridge.Update(header)
+			log.WithField("number", header.NumberArray()).Debugln("Serving work", header.SealHash())
+		case pause := <-m.pauseCh:
+			bridge.SetPaused(pause)
+			if pause {
+				atomic.StoreInt32(&m.paused, 1)
+				log.Println("Bridge paused, not serving work")
+			} else {
+				atomic.StoreInt32(&m.paused, 0)
+				log.Println("Bridge resumed")
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// queueWork moves work received from the source into the work queue,
+// dropping duplicates.
+func (m *Miner) queueWork(work *util.WorkQueue) {
+	for {
+		select {
+		case header := <-m.updateCh:
+			atomic.StoreInt64(&m.lastWork, time.Now().UnixNano())
+			util.LogEvent(util.EventWork, util.EventFields{"number": header.NumberArray(), "sealHash": header.SealHash(), "difficulty": header.Difficulty()})
+			util.CaptureWork(header)
+			m.tracer.Received(header)
+			if util.ChaosFault(util.ChaosDelay) {
+				time.Sleep(util.ChaosLatency())
+			}
+			if !work.Push(header) {
+				log.WithField("sealHash", header.SealHash()).Debugln("Dropping duplicate work")
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// headerTime is the timestamp to seal work with: the rig clock, or with
+// TimestampClamp the rig clock held within MaxDrift of the node's clock as
+// estimated from the work's timestamp and age, never before the node's
+// timestamp.
+func (m *Miner) headerTime(nodeTime uint64, arrived time.Time) uint64 {
+	now := time.Now()
+	if m.config.Timestamp.Mode != util.TimestampClamp || nodeTime == 0 {
+		return uint64(now.Unix())
+	}
+	drift := time.Duration(m.config.Timestamp.MaxDrift) * time.Second
+	if drift <= 0 {
+		drift = defaultTimestampDrift * time.Second
+	}
+	node := time.Unix(int64(nodeTime), 0).Add(now.Sub(arrived))
+	if now.Before(node.Add(-drift)) {
+		now = node.Add(-drift)
+	} else if now.After(node.Add(drift)) {
+		now = node.Add(drift)
+	}
+	if stamp := uint64(now.Unix()); stamp > nodeTime {
+		return stamp
+	}
+	return nodeTime
+}
+
+// checkClockSkew warns when the rig clock is off from NTP time by enough that
+// stamping headers with it risks rejection or orphaned blocks.
+func checkClockSkew(server string) {
+	offset, err := util.ClockOffset(server, clockSkewTimeout)
+	if err != nil {
+		log.Warnln("Unable to check the clock against NTP server", server, ":", err)
+		return
+	}
+	if offset < -clockSkewWarning || offset > clockSkewWarning {
+		log.WithField("offset", offset).Warnln("The system clock is off by", offset.Round(time.Millisecond), "from", server, ", fix the clock or set Timestamp.Mode to clamp or off")
+		return
+	}
+	log.WithField("offset", offset).Debugln("System clock is within", clockSkewWarning, "of", server)
+}
+
+// miningLoop iterates on a new header and passes the result to m.resultCh. The result is called within the method.
+func (m *Miner) miningLoop() error {
+	var (
+		stopCh chan struct{}
+		paused bool
+		// Set once the current work has expired or lost its parent
+		stale  bool
+		expiry <-chan time.Time
+		roll   <-chan time.Time
+		// The current work's timestamp and sealhash as served and when
+		// it arrived
+		nodeTime uint64
+		workSeal common.Hash
+		arrived  time.Time
+	)
+	// interrupt aborts the in-flight sealing task.
+	interrupt := func() {
+		if stopCh != nil {
+			close(stopCh)
+			stopCh = nil
+		}
+		expiry = nil
+		roll = nil
+	}
+	// seal interrupts the previous sealing operation and starts on the header.
+	seal := func(header *types.Header) {
+		interrupt()
+		if header.Difficulty().Sign() == 0 {
+			// No work received yet.
+			return
+		}
+		stopCh = make(chan struct{})
+		if m.config.TestDifficulty > 0 {
+			header = types.CopyHeader(header)
+			header.SetDifficulty(big.NewInt(m.config.TestDifficulty))
+		}
+		if header.Time() > 0 {
+			atomic.StoreInt64(&m.workLatency, int64(time.Since(time.Unix(int64(header.Time()), 0))))
+		}
+		if m.getworkClient == nil && m.config.Timestamp.Mode != util.TimestampOff {
+			// Getwork solutions are matched by sealhash, so that work is sealed as served.
+			// Stamp a copy, the interrupted workers may still be reading the header.
+			header = types.CopyHeader(header)
+			header.SetTime(m.headerTime(nodeTime, arrived))
+			if m.config.TimeRollInterval > 0 {
+				roll = time.After(time.Duration(m.config.TimeRollInterval) * time.Second)
+			}
+		}
+		util.LogEvent(util.EventSeal, util.EventFields{"sealHash": header.SealHash(), "threads": m.engine.Threads()})
+		m.tracer.Sealed(workSeal, header.SealHash())
+		atomic.StoreInt64(&m.sealStart, time.Now().UnixNano())
+		if err := m.engine.Seal(header, m.resultCh, stopCh); err != nil {
+			log.Errorln("Block sealing failed", "err", err)
+		}
+		if m.config.MaxWorkAge > 0 {
+			expiry = time.After(time.Duration(m.config.MaxWorkAge) * time.Second)
+		}
+	}
+	work := util.NewWorkQueue()
+	go m.queueWork(work)
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case now := <-heartbeat.C:
+			atomic.StoreInt64(&m.heartbeat, now.UnixNano())
+		case <-work.Ready():
+			header := work.Pop()
+			if header == nil {
+				continue
+			}
+			// Mine the header here
+			// Return the valid header with proper nonce and mix digest
+			number := [common.HierarchyDepth]uint64{header.NumberU64(common.PRIME_CTX), header.NumberU64(common.REGION_CTX), header.NumberU64(common.ZONE_CTX)}
+			primeStr := fmt.Sprint(number[common.PRIME_CTX])
+			regionStr := fmt.Sprint(number[common.REGION_CTX])
+			zoneStr := fmt.Sprint(number[common.ZONE_CTX])
+			if number != m.previousNumber {
+				if number[common.PRIME_CTX] != m.previousNumber[common.PRIME_CTX] {
+					primeStr = color.Ize(color.Red, primeStr)
+					regionStr = color.Ize(color.Red, regionStr)
+					zoneStr = color.Ize(color.Red, zoneStr)
+				} else if number[common.REGION_CTX] != m.previousNumber[common.REGION_CTX] {
+					regionStr = color.Ize(color.Yellow, regionStr)
+					zoneStr = color.Ize(color.Yellow, zoneStr)
+				} else if number[common.ZONE_CTX] != m.previousNumber[common.ZONE_CTX] {
+					zoneStr = color.Ize(color.Blue, zoneStr)
+				}
+				log.WithFields(log.Fields{"number": number, "difficulty": header.Difficulty()}).Infoln("Mining Block: ", fmt.Sprintf("[%s %s %s]", primeStr, regionStr, zoneStr), "location", header.Location(), "difficulty", header.Difficulty())
+				// The work asked for after logging in again is for the same
+				// height, so it doesn't rotate again.
+				if m.rotator != nil && m.config.RewardRotation == util.RotateJob && m.previousNumber != ([common.HierarchyDepth]uint64{}) {
+					go m.rotateRewardAddress()
+				}
+			}
+			m.previousNumber = [common.HierarchyDepth]uint64{header.NumberU64(common.PRIME_CTX), header.NumberU64(common.REGION_CTX), header.NumberU64(common.ZONE_CTX)}
+			m.statsLock.Lock()
+			m.workNumber = m.previousNumber
+			m.workLocation = header.Location()
+			m.workParent = header.ParentHash(common.ZONE_CTX)
+			m.statsLock.Unlock()
+			m.header = header
+			nodeTime, workSeal, arrived = header.Time(), header.SealHash(), time.Now()
+			stale = false
+			if !paused {
+				seal(header)
+			}
+		case pause := <-m.pauseCh:
+			if pause == paused {
+				continue
+			}
+			paused = pause
+			if paused {
+				atomic.StoreInt32(&m.paused, 1)
+			} else {
+				atomic.StoreInt32(&m.paused, 0)
+			}
+			if paused {
+				log.Println("Mining paused")
+				interrupt()
+			} else {
+				log.Println("Mining resumed")
+				if !stale {
+					seal(m.header)
+				}
+			}
+		case <-roll:
+			// Long rounds would otherwise submit an ever older timestamp.
+			log.WithField("number", m.previousNumber).Debugln("No new work for", m.config.TimeRollInterval, "seconds, refreshing the header timestamp")
+			deadline := expiry
+			// Stop the workers before stamping, a nonce found across the
+			// write would not match the sealhash it is verified against.
+			interrupt()
+			// Stamped here too so the refresh doesn't count as work latency.
+			header := types.CopyHeader(m.header)
+			header.SetTime(m.headerTime(nodeTime, arrived))
+			seal(header)
+			if deadline != nil {
+				expiry = deadline
+			}
+		case <-expiry:
+			log.WithField("number", m.previousNumber).Warnln("Work older than", m.config.MaxWorkAge, "seconds, abandoning it")
+			interrupt()
+			stale = true
+			m.refetchWork()
+		case parent := <-m.staleCh:
+			if stale || parent != m.header.ParentHash(common.ZONE_CTX) {
+				continue
+			}
+			log.WithField("number", m.previousNumber).Warnln("Work parent is no longer the chain tip, abandoning it")
+			interrupt()
+			stale = true
+			m.refetchWork()
+		case <-m.quit:
+			interrupt()
+			return nil
+		}
+	}
+}
+
+// Reasons sealing is paused for. Each holds it paused until released, so one
+// source resuming doesn't override another's pause.
+const (
+	pauseOperator = "operator"
+	pauseThermal  = "thermal"
+	pausePolicy   = "policy"
+	pauseIdle     = "idle"
+)
+
+// Pause stops sealing on the operator's behalf until Resume is called. New
+// work is still tracked.
+func (m *Miner) Pause() {
+	m.pauseFor(pauseOperator)
+}
+
+// Resume releases the operator's pause, restarting sealing on the latest work
+// unless something else still holds it paused.
+func (m *Miner) Resume() {
+	m.resumeFor(pauseOperator)
+}
+
+// pauseFor holds sealing paused for reason until resumeFor releases it.
+func (m *Miner) pauseFor(reason string) {
+	m.pauseLock.Lock()
+	defer m.pauseLock.Unlock()
+	if m.pauseReasons[reason] {
+		return
+	}
+	if m.pauseReasons == nil {
+		m.pauseReasons = make(map[string]bool)
+	}
+	m.pauseReasons[reason] = true
+	if len(m.pauseReasons) == 1 {
+		m.setPaused(true)
+	}
+}
+
+// resumeFor releases the pause held for reason, resuming sealing once no
+// other reason holds it.
+func (m *Miner) resumeFor(reason string) {
+	m.pauseLock.Lock()
+	defer m.pauseLock.Unlock()
+	if !m.pauseReasons[reason] {
+		return
+	}
+	delete(m.pauseReasons, reason)
+	if len(m.pauseReasons) == 0 {
+		m.setPaused(false)
+		return
+	}
+	reasons := make([]string, 0, len(m.pauseReasons))
+	for held := range m.pauseReasons {
+		reasons = append(reasons, held)
+	}
+	sort.Strings(reasons)
+	log.Println("Mining stays paused for", strings.Join(reasons, ", "))
+}
+
+// pausedFor reports whether reason holds sealing paused.
+func (m *Miner) pausedFor(reason string) bool {
+	m.pauseLock.Lock()
+	defer m.pauseLock.Unlock()
+	return m.pauseReasons[reason]
+}
+
+// setPaused pauses or resumes the sealing loops of every location.
+func (m *Miner) setPaused(pause bool) {
+	select {
+	case m.pauseCh <- pause:
+	case <-m.quit:
+	}
+	for _, z := range m.zones {
+		z.setPaused(pause)
+	}
+}
+
+// Reload rereads the config file and applies a changed thread count, reward
+// address and log level without restarting. Other changes are logged as
+// needing a restart.
+func (m *Miner) Reload() error {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	config, deprecated, err := readConfig()
+	if err != nil {
+		return err
+	}
+	for _, warning := range deprecated {
+		log.Warnln(warning)
+	}
+	if err := m.opts.apply(&config); err != nil {
+		return fmt.Errorf("invalid flags: %w", err)
+	}
+	if m.opts.simulate || m.opts.replay != "" {
+		config.GetworkURL = m.loaded.GetworkURL
+		config.GetworkInterval = m.loaded.GetworkInterval
+	}
+	if err := validateConfig(config); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	old := m.loaded
+	// Logging is only set up again when the config changes it, so a level set
+	// through the control listener survives unrelated reloads.
+	level, format := logSettings(config)
+	if oldLevel, oldFormat := logSettings(old); level != oldLevel || format != oldFormat {
+		if err := setupLogging(config); err != nil {
+			return err
+		}
+		log.Println("Logging at level", log.GetLevel())
+	}
+	m.loaded = config
+	if threads := configuredThreads(config); threads != configuredThreads(old) {
+		if threads == 0 {
+			threads = runtime.GOMAXPROCS(0)
+		}
+		m.SetThreads(threads)
+	}
+	if m.tenants == nil && m.rotator == nil {
+		m.reloadRewardAddress(config)
+		for _, z := range m.zones {
+			z.reloadRewardAddress(config)
+		}
+	}
+	// Mask the settings applied above to spot the ones that weren't.
+	rest := config
+	rest.Threads = old.Threads
+	if dutyCycle(config) == dutyCycle(old) {
+		rest.Preset = old.Preset
+	}
+	rest.RewardAddress, rest.ZoneRewardAddresses = old.RewardAddress, old.ZoneRewardAddresses
+	rest.LogLevel, rest.LogFormat = old.LogLevel, old.LogFormat
+	if !reflect.DeepEqual(rest, old) {
+		log.Warnln("Config changes other than threads, preset, reward address and logging only apply after a restart")
+	}
+	log.Println("Reloaded config")
+	return nil
+}
+
+// reloadRewardAddress switches to the reward address config sets for the
+// miner's location, logging the proxy session in with it. Nodes mine to their
+// own coinbase, so there is nothing to switch without a proxy.
+func (m *Miner) reloadRewardAddress(config util.Config) {
+	if !m.config.Proxy {
+		return
+	}
+	m.setRewardAddress(config.RewardAddressFor(m.config.Location))
+}
+
+// configuredThreads is the thread count set by Threads or the preset, 0 when
+// neither is set.
+func configuredThreads(config util.Config) int {
+	if config.Threads > 0 {
+		return config.Threads
+	}
+	if config.Preset != "" {
+		return int(math.Ceil(float64(runtime.GOMAXPROCS(0)) * util.Presets[config.Preset].CoreShare / 100))
+	}
+	return 0
+}
+
+// dutyCycle is the percentage of each thread's time spent hashing, set by
+// CPUPercent or the preset, 0 when neither is set.
+func dutyCycle(config util.Config) float64 {
+	if config.CPUPercent > 0 {
+		return config.CPUPercent
+	}
+	if config.Preset != "" {
+		return util.Presets[config.Preset].DutyCycle
+	}
+	return 0
+}
+
+// capThreads limits a thread count to the Guardrails.MaxCPUPercent ceiling.
+func (m *Miner) capThreads(threads int) int {
+	if ceiling := int(atomic.LoadInt32(&m.threadCeiling)); ceiling > 0 && (threads == 0 || threads > ceiling) {
+		return ceiling
+	}
+	return threads
+}
+
+// SetThreads changes the number of sealing threads. The engine restarts any
+// in-flight seal with the new count.
+func (m *Miner) SetThreads(threads int) {
+	if capped := m.capThreads(threads); capped != threads {
+		log.Println("Guardrails.MaxCPUPercent caps mining threads at", capped)
+		threads = capped
+	}
+	log.Println("Setting mining threads to", threads)
+	if len(m.zones) == 0 {
+		m.engine.SetThreads(threads)
+		return
+	}
+	split := m.splitThreads(threads)
+	m.engine.SetThreads(split[0])
+	for i, z := range m.zones {
+		z.engine.SetThreads(split[i+1])
+	}
+}
+
+// activeThreads returns the sealing thread count, resolving the engine's
+// default of 0 to every core.
+func (m *Miner) activeThreads() int {
+	threads := m.engine.Threads()
+	if threads == 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	return threads
+}
+
+// totalThreads returns the sealing thread count of the whole process, summed
+// over the zones. It is what SetThreads splits.
+func (m *Miner) totalThreads() int {
+	threads := m.activeThreads()
+	for _, z := range m.zones {
+		threads += z.activeThreads()
+	}
+	return threads
+}
+
+// totalHashrate returns the hashrate of the whole process, summed over the
+// zones.
+func (m *Miner) totalHashrate() float64 {
+	hashrate := m.engine.Hashrate()
+	for _, z := range m.zones {
+		hashrate += z.engine.Hashrate()
+	}
+	return hashrate
+}
+
+// SetLogLevel switches the log level (debug, info, warn or error) until the
+// next restart or config reload.
+func (m *Miner) SetLogLevel(level string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lvl)
+	log.Println("Logging at level", lvl)
+	return nil
+}
+
+// Snapshot saves the runtime stats to the snapshot file.
+func (m *Miner) Snapshot() error {
+	if m.config.SnapshotFile == "" {
+		return errors.New("no SnapshotFile configured")
+	}
+	snap := util.Snapshot{
+		Time:         time.Now(),
+		DevFeeBlocks: atomic.LoadUint64(&m.devFeeBlocks),
+		DevFeeTime:   time.Duration(atomic.LoadInt64(&m.devFeeTime)),
+	}
+	for i := range snap.BlocksFound {
+		snap.BlocksFound[i] = atomic.LoadUint64(&m.blocksFound[i])
+	}
+	if m.tenants != nil {
+		snap.Tenants = m.tenants.Stats()
+	}
+	if err := util.SaveSnapshot(m.config.SnapshotFile, snap); err != nil {
+		return err
+	}
+	log.Println("Saved snapshot to", m.config.SnapshotFile)
+	return nil
+}
+
+// restoreSnapshot picks up the stats saved by a previous run.
+func (m *Miner) restoreSnapshot() {
+	snap, err := util.LoadSnapshot(m.config.SnapshotFile)
+	if err != nil {
+		log.Warnln("Unable to restore snapshot: ", err)
+		return
+	}
+	if snap.Time.IsZero() {
+		return
+	}
+	m.blocksFound = snap.BlocksFound
+	m.devFeeBlocks = snap.DevFeeBlocks
+	m.devFeeTime = int64(snap.DevFeeTime)
+	if m.tenants != nil {
+		m.tenants.Restore(snap.Tenants)
+	}
+	log.Println("Restored snapshot from", snap.Time.Format(time.RFC3339), "blocks found", snap.BlocksFound)
+}
+
+// WatchHashRate is a simple method to watch the hashrate of our miner and log the output.
+func (m *Miner) hashratePrinter() {
+	sampler := time.NewTicker(hashrateSampleInterval)
+	ticker := time.NewTicker(hashrateReportInterval)
+	for {
+		select {
+		case <-sampler.C:
+			m.sampleHashrate(m.engine.Hashrate())
+		case <-ticker.C:
+			hashRate := m.engine.Hashrate()
+			m.checkHashrate(hashRate)
+			hr, units := util.HashrateUnits(hashRate)
+			hashrates := m.hashrates.Summary()
+			fields := log.Fields{"hashrate": hashRate, "ema1m": hashrates.EMA1m, "ema15m": hashrates.EMA15m, "ema1h": hashrates.EMA1h, "peak": hashrates.Peak, "average": hashrates.Average}
+			if len(m.config.Locations) > 1 {
+				fields["location"] = m.config.Location
+				log.WithFields(fields).Infoln("Current hashrate in location", m.config.Location, ": ", hr, units, "(", hashrates, ")")
+			} else {
+				log.WithFields(fields).Infoln("Current hashrate: ", hr, units, "(", hashrates, ")")
+			}
+			if submissions := m.submissionStats(); submissions.Total() > 0 {
+				log.WithFields(log.Fields{"accepted": submissions.Accepted, "rejected": submissions.Rejected, "stale": submissions.Stale}).Infoln("Submissions:", submissions)
+			}
+			if m.config.DevFee.Percent > 0 {
+				m.rewardLock.Lock()
+				active := m.devFeeActive
+				m.rewardLock.Unlock()
+				log.Println(color.Ize(color.Purple, "Dev fee: "), fmt.Sprintf("%g%% to %s, active %v, donated %v, blocks %d", m.config.DevFee.Percent, m.config.DevFee.Address, active, time.Duration(atomic.LoadInt64(&m.devFeeTime)).Round(time.Second), atomic.LoadUint64(&m.devFeeBlocks)))
+			}
+			if m.tenants != nil {
+				current := m.tenants.Current().Address
+				for _, tenant := range m.tenants.Stats() {
+					marker := ""
+					if tenant.Address == current {
+						marker = " (mining)"
+					}
+					log.Println(color.Ize(color.Cyan, "Tenant: "), fmt.Sprintf("%s%s mined %v, blocks %v", tenant.Name, marker, tenant.Time.Round(time.Second), tenant.Blocks))
+				}
+			}
+		}
+	}
+}
+
+// checkHashrate fires the low hashrate hook when the hashrate drops below
+// MinHashrate, once until it recovers. A paused miner isn't expected to hash.
+func (m *Miner) checkHashrate(hashrate float64) {
+	min := m.config.Hooks.MinHashrate
+	if min <= 0 || atomic.LoadInt32(&m.paused) == 1 {
+		return
+	}
+	if !m.lowHashrate && hashrate < min {
+		m.lowHashrate = true
+		log.Warnln("Hashrate", hashrate, "below the", min, "h/s threshold")
+		go m.fireHook(m.config.Hooks.OnLowHashrate, hookEvent{Event: "on_low_hashrate", Hashrate: hashrate})
+	} else if m.lowHashrate && hashrate >= min {
+		m.lowHashrate = false
+		log.Println("Hashrate back above the", min, "h/s threshold")
+	}
+}
+
+// startControlServer serves the pause/resume/intensity webhook for home
+// automation systems.
+func (m *Miner) startControlServer() {
+	if m.config.ControlToken == "" {
+		log.Warnln("Refusing to start control server without a ControlToken")
+		return
+	}
+	if err := m.config.Listeners.Control.Serve("control", util.NewControlHandler(m.config.ControlToken, m)); err != nil {
+		log.Warnln("Control server stopped: ", err)
+	}
+}
+
+// policyLoop evaluates the policy script against the live miner state and
+// applies its pause and thread decisions.
+func (m *Miner) policyLoop() {
+	interval := m.config.Policy.Interval
+	if interval <= 0 {
+		interval = defaultPolicyInterval
+	}
+	log.Println("Evaluating policy", m.config.Policy.Script, "every", interval, "seconds")
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			temp, _ := util.CPUTemperature()
+			load, _ := util.LoadAverage()
+			now := time.Now()
+			decision, err := m.policy.Evaluate(util.PolicyState{
+				Hashrate:      m.totalHashrate(),
+				Temperature:   temp,
+				Load:          load,
+				Threads:       m.totalThreads(),
+				Cores:         runtime.GOMAXPROCS(0),
+				Paused:        atomic.LoadInt32(&m.paused) == 1,
+				Connected:     atomic.LoadInt32(&m.connected) == 1,
+				Blocks:        m.foundBlocks(),
+				Hour:          now.Hour(),
+				Weekday:       int(now.Weekday()),
+				RewardAddress: m.currentRewardAddress(),
+			})
+			if err != nil {
+				log.Warnln("Policy evaluation failed: ", err)
+				continue
+			}
+			if decision.Threads != nil {
+				if threads := m.capThreads(int(*decision.Threads)); threads != m.totalThreads() {
+					log.Println("Policy set threads to", threads)
+					m.SetThreads(threads)
+				}
+			}
+			if decision.Pause != nil && *decision.Pause != m.pausedFor(pausePolicy) {
+				if *decision.Pause {
+					log.Println("Policy paused mining")
+					m.pauseFor(pausePolicy)
+				} else {
+					log.Println("Policy resumed mining")
+					m.resumeFor(pausePolicy)
+				}
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// startSNMPAgent serves the miner's core stats to SNMP monitoring.
+func (m *Miner) startSNMPAgent() {
+	community := m.config.SNMPCommunity
+	stats := func() util.SNMPStats {
+		temp, _ := util.CPUTemperature()
+		return util.SNMPStats{
+			Hashrate:    m.engine.Hashrate(),
+			Temperature: temp,
+			Blocks:      m.foundBlocks(),
+			Connected:   atomic.LoadInt32(&m.connected) == 1,
+			Threads:     m.totalThreads(),
+		}
+	}
+	if err := m.config.Listeners.SNMP.ServeSNMP(community, stats); err != nil {
+		log.Warnln("SNMP agent stopped: ", err)
+	}
+}
+
+// startMetricsServer serves the Prometheus metrics endpoint.
+func (m *Miner) startMetricsServer() {
+	if err := m.config.Listeners.Metrics.Serve("metrics", util.NewMetricsHandler(m.metricsStats)); err != nil {
+		log.Warnln("Metrics server stopped: ", err)
+	}
+}
+
+// metricsStats snapshots the counters exported as metrics.
+func (m *Miner) metricsStats() util.MetricsStats {
+	return util.MetricsStats{
+		Hashrate:     m.engine.Hashrate(),
+		Hashrates:    m.hashrates.Summary(),
+		Blocks:       m.foundBlocks(),
+		SubmitErrors: atomic.LoadUint64(&m.submitErrors),
+		Partial:      atomic.LoadUint64(&m.partialSubmissions),
+		Invalid:      atomic.LoadUint64(&m.invalidSolutions),
+		Duplicates:   atomic.LoadUint64(&m.duplicateSolutions),
+		Submissions:  m.submissionStats(),
+		Reconnects:   atomic.LoadUint64(&m.reconnects),
+		WorkLatency:  time.Duration(atomic.LoadInt64(&m.workLatency)),
+		Connected:    atomic.LoadInt32(&m.connected) == 1,
+		Threads:      m.totalThreads(),
+	}
+}
+
+// statsFileLoop appends a stats snapshot to the stats file every interval.
+// The last one is written on shutdown.
+func (m *Miner) statsFileLoop() {
+	interval := m.config.StatsFile.Interval
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	log.Println("Writing stats to", m.config.StatsFile.Path, "every", interval, "seconds")
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.writeStats(now)
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// writeStats appends a snapshot of the session to the stats file.
+func (m *Miner) writeStats(now time.Time) {
+	record := util.StatsRecord{
+		Time:        now,
+		Hashrate:    m.engine.Hashrate(),
+		Threads:     m.totalThreads(),
+		Blocks:      util.BlocksByContext(m.foundBlocks()),
+		Submissions: m.submissionStats(),
+		Invalid:     atomic.LoadUint64(&m.invalidSolutions),
+		Reconnects:  atomic.LoadUint64(&m.reconnects),
+	}
+	if err := m.stats.Write(record); err != nil {
+		log.Warnln("Unable to write stats file: ", err)
+	}
+}
+
+// influxLoop pushes the metrics to the InfluxDB endpoint, tagged with the rig
+// name (RigID, else the hostname) and the mined location.
+func (m *Miner) influxLoop() {
+	interval := m.config.Influx.Interval
+	if interval <= 0 {
+		interval = defaultInfluxInterval
+	}
+	rig := m.config.RigID
+	if rig == "" {
+		rig, _ = os.Hostname()
+	}
+	log.Println("Pushing metrics to InfluxDB at", m.config.Influx.URL, "every", interval, "seconds")
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			m.statsLock.Lock()
+			location := m.workLocation
+			m.statsLock.Unlock()
+			if location == nil {
+				location = m.config.Location
+			}
+			tags := map[string]string{"rig": rig, "location": location.Name()}
+			for key, value := range m.config.Influx.Tags {
+				tags[key] = value
+			}
+			if err := util.PushInflux(m.config.Influx.URL, m.config.Influx.Token, util.InfluxLines(m.metricsStats(), tags, now)); err != nil {
+				log.Warnln("Unable to push metrics to InfluxDB: ", err)
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// tracingLoop exports the traces of solved work as they finish.
+func (m *Miner) tracingLoop() {
+	log.Println("Exporting traces to", m.config.Tracing.Endpoint)
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.tracer.Flush(false); err != nil {
+				log.Warnln("Unable to export traces: ", err)
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// startPprofServer serves the runtime profiles.
+func (m *Miner) startPprofServer() {
+	if err := m.config.Listeners.Pprof.Serve("pprof", util.NewPprofHandler()); err != nil {
+		log.Warnln("Pprof server stopped: ", err)
+	}
+}
+
+// startStatusServer serves the JSON status endpoint.
+func (m *Miner) startStatusServer() {
+	if err := m.config.Listeners.Status.Serve("status", util.NewStatusHandler(m.fullStatus, m.liveness, m.readiness), util.ProbePaths...); err != nil {
+		log.Warnln("Status server stopped: ", err)
+	}
+}
+
+// liveness fails once the sealing loop of the miner or of one of its zones
+// stopped beating, so a wedged miner gets restarted.
+func (m *Miner) liveness() error {
+	for _, z := range append([]*Miner{m}, m.zones...) {
+		beat := time.Unix(0, atomic.LoadInt64(&z.heartbeat))
+		if beat.Before(z.start) {
+			beat = z.start
+		}
+		if age := time.Since(beat); age > heartbeatTimeout {
+			return fmt.Errorf("sealing loop for %s stalled for %s", z.config.Location.Name(), age.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// readiness fails while the miner or one of its zones is disconnected from
+// its work source or has had no work for ReadyWorkAge seconds.
+func (m *Miner) readiness() error {
+	maxAge := time.Duration(m.config.ReadyWorkAge) * time.Second
+	if maxAge <= 0 {
+		maxAge = defaultReadyWorkAge * time.Second
+	}
+	for _, z := range append([]*Miner{m}, m.zones...) {
+		if atomic.LoadInt32(&z.connected) == 0 {
+			return fmt.Errorf("%s is not connected to its work source", z.config.Location.Name())
+		}
+		last := atomic.LoadInt64(&z.lastWork)
+		if last == 0 {
+			return fmt.Errorf("no work received yet for %s", z.config.Location.Name())
+		}
+		if age := time.Since(time.Unix(0, last)); age > maxAge {
+			return fmt.Errorf("no work for %s in %s", z.config.Location.Name(), age.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// fullStatus reports the miner's state with its zones.
+func (m *Miner) fullStatus() util.StatusReport {
+	report := m.status()
+	for _, z := range m.zones {
+		report.Zones = append(report.Zones, z.status())
+	}
+	return report
+}
+
+// dashboardLoop redraws the terminal dashboard every second.
+func (m *Miner) dashboardLoop() {
+	ticker := time.NewTicker(dashboardInterval)
+	defer ticker.Stop()
 	for {
 		select {
-		case header := <-m.resultCh:
-			_, order, err := m.engine.CalcOrder(header)
+		case <-ticker.C:
+			m.dashboard.Render(m.fullStatus(), USER_AGENT_VER)
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// status reports the miner's own state, without its zones.
+func (m *Miner) status() util.StatusReport {
+	mode := "node"
+	if m.config.Proxy {
+		mode = "proxy"
+	} else if m.config.GetworkURL != "" {
+		mode = "getwork"
+	}
+	m.statsLock.Lock()
+	number, location := m.workNumber, m.workLocation
+	m.statsLock.Unlock()
+	if location == nil {
+		location = m.config.Location
+	}
+	return util.StatusReport{
+		Mode:        mode,
+		Location:    util.LocationIndices(location),
+		Number:      number,
+		Hashrate:    m.engine.Hashrate(),
+		Hashrates:   m.hashrates.Summary(),
+		Threads:     m.totalThreads(),
+		Blocks:      util.BlocksByContext(m.foundBlocks()),
+		Submissions: m.submissionStats(),
+		Connected:   atomic.LoadInt32(&m.connected) == 1,
+		Paused:      atomic.LoadInt32(&m.paused) == 1,
+		Uptime:      time.Since(m.start).Seconds(),
+	}
+}
+
+// coolingLoop fires the cooling hooks when the CPU temperature crosses the
+// configured thresholds, pausing or throttling mining in between when the
+// cooling action says so. LowTemp gives hysteresis so the hooks don't flap.
+func (m *Miner) coolingLoop() {
+	cooling := m.config.Cooling
+	if cooling.LowTemp <= 0 {
+		cooling.LowTemp = cooling.HighTemp
+	}
+	ticker := time.NewTicker(coolingInterval)
+	hot := false
+	threads := 0 // before throttling
+	for {
+		select {
+		case <-ticker.C:
+			temp, err := util.CPUTemperature()
 			if err != nil {
-				log.Println("Mined block had invalid order: err=", err)
+				if cooling.Action != "" {
+					log.Errorln("Unable to read CPU temperature, mining without thermal protection: ", err)
+				} else {
+					log.Warnln("Unable to read CPU temperature, disabling cooling hooks: ", err)
+				}
 				return
 			}
-			if !m.config.Proxy {
-				for i := common.HierarchyDepth - 1; i >= order; i-- {
-					err := m.sendMinedHeaderNodes(i, header)
-					if err != nil {
-						// Go back to waiting on the next block.
-						fmt.Errorf("error submitting block to context %d: %v", order, err)
-						continue
+			if !hot && temp >= cooling.HighTemp {
+				hot = true
+				log.Println("CPU temperature above threshold: ", temp)
+				go m.fireHook(cooling.OnHigh, hookEvent{Event: "temperature_high", Temperature: temp})
+				switch cooling.Action {
+				case util.CoolingPause:
+					log.Warnln("Pausing mining until the CPU cools down to", cooling.LowTemp)
+					m.pauseFor(pauseThermal)
+				case util.CoolingThrottle:
+					threads = m.totalThreads()
+					throttled := threads / 2
+					if throttled < 1 {
+						throttled = 1
 					}
+					log.Warnln("Throttling mining to", throttled, "threads until the CPU cools down to", cooling.LowTemp)
+					m.SetThreads(throttled)
 				}
-			} else {
+			} else if hot && temp <= cooling.LowTemp {
+				hot = false
+				log.Println("CPU temperature back below threshold: ", temp)
+				go m.fireHook(cooling.OnLow, hookEvent{Event: "temperature_low", Temperature: temp})
+				switch cooling.Action {
+				case util.CoolingPause:
+					m.resumeFor(pauseThermal)
+				case util.CoolingThrottle:
+					m.SetThreads(threads)
+				}
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// cpuCeiling returns the most threads Guardrails.MaxCPUPercent allows, or 0
+// when the threads are not capped.
+func cpuCeiling(guardrails util.Guardrails) int {
+	if guardrails.MaxCPUPercent <= 0 {
+		return 0
+	}
+	ceiling := int(float64(runtime.GOMAXPROCS(0)) * guardrails.MaxCPUPercent / 100)
+	if ceiling < 1 {
+		ceiling = 1
+	}
+	return ceiling
+}
+
+// guardrailLoop caps the mining threads at MaxCPUPercent of the cores and sheds
+// threads while the rest of the system is busy, so co-hosted services such as
+// a Quai node are not starved by their own miner.
+func (m *Miner) guardrailLoop() {
+	guardrails := m.config.Guardrails
+	cores := runtime.GOMAXPROCS(0)
+	ceiling := m.totalThreads()
+	if capped := cpuCeiling(guardrails); capped > 0 && capped < ceiling {
+		ceiling = capped
+	}
+	threads := ceiling
+	m.SetThreads(threads)
+	if guardrails.MaxLoad <= 0 {
+		return
+	}
+	// The load average trails the miner's own CPU use by about a minute, so
+	// that use is averaged the same way before taking it out of the load.
+	decay := math.Exp(-float64(guardrailInterval) / float64(time.Minute))
+	own := 0.0
+	cpuTime, err := util.ProcessCPUTime()
+	if err != nil {
+		log.Warnln("Unable to read the miner's CPU time, disabling load guardrail: ", err)
+		return
+	}
+	sampled := time.Now()
+	var changed time.Time
+	ticker := time.NewTicker(guardrailInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+		load, err := util.LoadAverage()
+		if err != nil {
+			log.Warnln("Unable to read system load, disabling load guardrail: ", err)
+			return
+		}
+		used, err := util.ProcessCPUTime()
+		if err != nil {
+			log.Warnln("Unable to read the miner's CPU time, disabling load guardrail: ", err)
+			return
+		}
+		now := time.Now()
+		own = own*decay + float64(used-cpuTime)/float64(now.Sub(sampled))*(1-decay)
+		cpuTime, sampled = used, now
+		// Take our own share out of the load to see what everything else uses.
+		other := load - own
+		if other < 0 {
+			other = 0
+		}
+		budget := guardrails.MaxLoad*float64(cores) - other
+		allowed := int(budget)
+		if allowed > threads {
+			// Grow back only with headroom to spare, so the count doesn't
+			// flap on the boundary.
+			allowed = int(budget - guardrailHeadroom)
+			if allowed < threads {
+				allowed = threads
+			}
+		}
+		if allowed > ceiling {
+			allowed = ceiling
+		}
+		if allowed < 1 {
+			// A negative thread count idles the engine.
+			allowed = -1
+		}
+		// Let the load average catch up with the last change first.
+		if allowed != threads && time.Since(changed) >= guardrailSettle {
+			log.WithField("minerLoad", own).Println("System load", load, "adjusting mining threads from", threads, "to", allowed)
+			threads = allowed
+			changed = time.Now()
+			m.SetThreads(threads)
+		}
+	}
+}
+
+// idleLoop pauses mining while the desktop is in use and resumes it once the
+// user has been away for Idle.After seconds. Away time is the input idle time
+// where it can be read, else how long the load has stayed below Idle.MaxLoad.
+func (m *Miner) idleLoop() {
+	idle := m.config.Idle
+	after := time.Duration(idle.After) * time.Second
+	input := !util.RunningAsService()
+	if input {
+		if _, err := util.UserIdleTime(); err != nil {
+			if idle.MaxLoad <= 0 {
+				log.Errorln("Unable to read the desktop idle time, mining regardless of user activity: ", err)
+				return
+			}
+			log.Warnln("Unable to read the desktop idle time, judging activity by the system load instead: ", err)
+			input = false
+		}
+	} else if idle.MaxLoad <= 0 {
+		log.Errorln("A service can't see the desktop idle time, set Idle.MaxLoad to pause on system load")
+		return
+	}
+	cores := float64(runtime.GOMAXPROCS(0))
+	var quietSince time.Time
+	paused := false
+	// away reports how long the host has been idle.
+	away := func() (time.Duration, error) {
+		if input {
+			return util.UserIdleTime()
+		}
+		load, err := util.LoadAverage()
+		if err != nil {
+			return 0, err
+		}
+		// Take our own threads out of the load while they are hashing.
+		if threads := m.totalThreads(); atomic.LoadInt32(&m.paused) == 0 && threads > 0 {
+			load -= float64(threads)
+		}
+		if load/cores >= idle.MaxLoad {
+			quietSince = time.Time{}
+			return 0, nil
+		}
+		if quietSince.IsZero() {
+			quietSince = time.Now()
+		}
+		return time.Since(quietSince), nil
+	}
+	check := func() bool {
+		idleFor, err := away()
+		if err != nil {
+			log.Errorln("Unable to tell whether the desktop is in use, mining regardless of user activity: ", err)
+			m.resumeFor(pauseIdle)
+			return false
+		}
+		if !paused && idleFor < after {
+			paused = true
+			log.Println("Desktop in use, pausing mining until it has been idle for", after)
+			m.pauseFor(pauseIdle)
+		} else if paused && idleFor >= after {
+			paused = false
+			log.Println("Desktop idle for", idleFor.Round(time.Second), "resuming mining")
+			m.resumeFor(pauseIdle)
+		}
+		return true
+	}
+	if !check() {
+		return
+	}
+	ticker := time.NewTicker(idleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !check() {
+				return
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// autoTuneLoop hill-climbs the thread count: it measures the hashrate one
+// thread above or below the current count and keeps the change when it mines
+// faster, else goes back and tries the other way next. The current count is
+// measured again after every failed trial, so the search follows the other
+// work on the machine as it changes through the day.
+func (m *Miner) autoTuneLoop() {
+	trial := time.Duration(m.config.AutoTune.Trial) * time.Second
+	if trial <= 0 {
+		trial = time.Duration(defaultAutoTuneTrial) * time.Second
+	}
+	cores := runtime.GOMAXPROCS(0)
+	if ceiling := int(atomic.LoadInt32(&m.threadCeiling)); ceiling > 0 {
+		// SetThreads wouldn't go past the guardrail anyway.
+		cores = ceiling
+	}
+	// measure waits out a trial, reporting false if mining was paused
+	// meanwhile or the miner is stopping.
+	measure := func() (float64, bool) {
+		select {
+		case <-time.After(trial):
+		case <-m.quit:
+			return 0, false
+		}
+		return m.totalHashrate(), atomic.LoadInt32(&m.paused) == 0
+	}
+	log.Println("Auto-tuning the thread count, measuring each for", trial)
+	threads := m.totalThreads()
+	best, ok := measure()
+	direction := 1
+	for {
+		select {
+		case <-m.quit:
+			return
+		default:
+		}
+		if !ok {
+			threads = m.totalThreads()
+			best, ok = measure()
+			continue
+		}
+		candidate := threads + direction
+		if candidate < 1 || candidate > cores {
+			direction = -direction
+			candidate = threads + direction
+			if candidate < 1 || candidate > cores {
+				log.Println("Auto-tune stopped, a single core leaves nothing to tune")
+				return
+			}
+		}
+		m.SetThreads(candidate)
+		hashrate, measured := measure()
+		if !measured {
+			m.SetThreads(threads)
+			ok = false
+			continue
+		}
+		if hashrate > best*(1+autoTuneMargin) {
+			log.WithFields(log.Fields{"threads": candidate, "hashrate": hashrate}).Infoln("Auto-tune kept", candidate, "threads, hashrate", hashrate, "up from", best)
+			threads, best = candidate, hashrate
+			continue
+		}
+		log.WithFields(log.Fields{"threads": threads, "hashrate": hashrate}).Debugln("Auto-tune tried", candidate, "threads at hashrate", hashrate, "against", best, "staying at", threads)
+		m.SetThreads(threads)
+		direction = -direction
+		best, ok = measure()
+	}
+}
+
+// hashrateReportLoop reports the hashrate to the proxy every
+// HashrateInterval seconds, so pools can show it per rig.
+func (m *Miner) hashrateReportLoop() {
+	rig := util.RigID(m.config.RigID)
+	log.Println("Reporting hashrate to the proxy every", m.config.HashrateInterval, "seconds as rig", rig.Hex())
+	ticker := time.NewTicker(time.Duration(m.config.HashrateInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+		if atomic.LoadInt32(&m.connected) == 0 {
+			continue
+		}
+		err := m.proxy().SubmitHashrate(m.engine.Hashrate(), rig)
+		if errors.Is(err, util.ErrMethodNotFound) {
+			log.Warnln("Proxy doesn't accept hashrate reports, no longer sending them")
+			return
+		} else if err != nil {
+			log.Debugln("Hashrate report failed: ", err)
+		}
+	}
+}
+
+// telemetryLoop periodically reports anonymized hardware and hashrate figures
+// to the opt-in telemetry endpoint.
+func (m *Miner) telemetryLoop() {
+	interval := m.config.Telemetry.Interval
+	if interval <= 0 {
+		interval = defaultTelemetryInterval
+	}
+	log.Println("Anonymous telemetry enabled, reporting to", m.config.Telemetry.URL, "every", interval, "seconds")
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report := util.TelemetryReport{
+				CPUModel: util.CPUModel(),
+				Cores:    runtime.NumCPU(),
+				OS:       runtime.GOOS,
+				Arch:     runtime.GOARCH,
+				Backend:  minerBackend,
+				Threads:  m.totalThreads(),
+				Hashrate: m.engine.Hashrate(),
+				Version:  USER_AGENT_VER,
+			}
+			if err := util.SendTelemetry(m.config.Telemetry.URL, report, m.profile.CompressTelemetry); err != nil {
+				log.Warnln("Unable to send telemetry: ", err)
+			}
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// balanceAddresses returns the reward addresses balanceLoop reports on.
+func (m *Miner) balanceAddresses() []common.Address {
+	if len(m.config.RewardAddresses) > 0 {
+		addresses := make([]common.Address, 0, len(m.config.RewardAddresses))
+		for _, reward := range m.config.RewardAddresses {
+			addresses = append(addresses, common.HexToAddress(reward.Address))
+		}
+		return addresses
+	}
+	m.rewardLock.Lock()
+	defer m.rewardLock.Unlock()
+	return []common.Address{common.HexToAddress(m.rewardAddress)}
+}
+
+// ledgerBlock is a block found this session, matched against the chain by
+// balanceLoop to learn the coinbase credit it earned.
+type ledgerBlock struct {
+	number   uint64
+	hash     common.Hash
+	coinbase common.Address
+}
+
+// recordLedger adds a found block for balanceLoop to settle.
+func (m *Miner) recordLedger(header *types.Header) {
+	m.statsLock.Lock()
+	defer m.statsLock.Unlock()
+	m.ledger = append(m.ledger, ledgerBlock{number: header.NumberU64(common.ZONE_CTX), hash: header.Hash(), coinbase: header.Coinbase()})
+}
+
+// balanceLoop periodically queries the zone node for the balance of the reward
+// addresses and logs each one's balance next to what its blocks found this
+// session earned. A found block's earnings are its coinbase's balance change
+// over the block, once the block is on the canonical chain.
+func (m *Miner) balanceLoop() {
+	client := m.sliceClients[common.ZONE_CTX]
+	if client == nil {
+		loc := m.config.Location
+		if int(loc.Region()) >= len(m.config.ZoneURLs) || int(loc.Zone()) >= len(m.config.ZoneURLs[loc.Region()]) {
+			log.Warnln("No zone node configured for location", loc, "disabling balance tracking")
+			return
+		}
+		var err error
+		client, err = dialNode(outboundDialer(m.config), m.config.ZoneURLs[loc.Region()][loc.Zone()])
+		if err != nil {
+			log.Warnln("Unable to connect to zone node, disabling balance tracking: ", err)
+			return
+		}
+	}
+	earned := make(map[common.Address]*big.Int)
+	credited := make(map[common.Address]int)
+	// settle credits the found blocks that made the chain and drops the
+	// ones that didn't, leaving those the node can't tell about yet.
+	settle := func() {
+		m.statsLock.Lock()
+		pending := append([]ledgerBlock(nil), m.ledger...)
+		m.statsLock.Unlock()
+		settled := make(map[common.Hash]bool)
+		for _, block := range pending {
+			ctx := context.Background()
+			canonical, err := client.HeaderByNumber(ctx, new(big.Int).SetUint64(block.number))
+			if err != nil || canonical == nil {
+				continue
+			}
+			if canonical.Hash() != block.hash {
+				log.WithField("hash", block.hash).Debugln("Found block", block.number, "is not on the canonical chain, it earned nothing")
+				settled[block.hash] = true
+				continue
+			}
+			after, err := client.BalanceAt(ctx, block.coinbase, new(big.Int).SetUint64(block.number))
+			if err != nil {
+				continue
+			}
+			before, err := client.BalanceAt(ctx, block.coinbase, new(big.Int).SetUint64(block.number-1))
+			if err != nil {
+				continue
+			}
+			if earned[block.coinbase] == nil {
+				earned[block.coinbase] = new(big.Int)
+			}
+			earned[block.coinbase].Add(earned[block.coinbase], after.Sub(after, before))
+			credited[block.coinbase]++
+			settled[block.hash] = true
+		}
+		m.statsLock.Lock()
+		ledger := m.ledger[:0]
+		for _, block := range m.ledger {
+			if !settled[block.hash] {
+				ledger = append(ledger, block)
+			}
+		}
+		m.ledger = ledger
+		m.statsLock.Unlock()
+	}
+	report := func() {
+		settle()
+		for _, address := range m.balanceAddresses() {
+			balance, err := client.BalanceAt(context.Background(), address, nil)
+			if err != nil {
+				log.Warnln("Unable to fetch reward balance of", address.Hex(), ": ", err)
+				continue
+			}
+			session := earned[address]
+			if session == nil {
+				session = new(big.Int)
+			}
+			log.WithField("address", address.Hex()).Println("Reward balance of", address.Hex(), toQuai(balance), "QUAI, earned", toQuai(session), "QUAI from", credited[address], "blocks this session")
+		}
+		log.Println("Blocks found this session", m.foundBlocks())
+	}
+	report()
+	ticker := time.NewTicker(time.Duration(m.config.BalanceInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			report()
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+// foundBlocks returns the blocks found this session, per context.
+func (m *Miner) foundBlocks() [common.HierarchyDepth]uint64 {
+	var found [common.HierarchyDepth]uint64
+	for i := range found {
+		found[i] = atomic.LoadUint64(&m.blocksFound[i])
+	}
+	return found
+}
+
+// toQuai formats a wei amount in QUAI.
+func toQuai(wei *big.Int) string {
+	return new(big.Float).Quo(new(big.Float).SetInt(wei), weiPerQuai).Text('f', 6)
+}
+
+// fireHook runs an external hook and logs any failure.
+func (m *Miner) fireHook(hook util.Hook, event hookEvent) {
+	event.Time = time.Now().Unix()
+	event.Location = util.LocationIndices(m.config.Location)
+	if err := hook.Fire(event); err != nil {
+		log.Warnln("Hook for event", event.Event, "failed: ", err)
+	}
+}
+
+// notifyBlock posts a found block to the block webhook.
+func (m *Miner) notifyBlock(header *types.Header, order int) {
+	number := make([]uint64, common.HierarchyDepth)
+	for ctx := range number {
+		number[ctx] = header.NumberU64(ctx)
+	}
+	location := header.Location()
+	if len(location) == 0 {
+		location = m.config.Location
+	}
+	notification := util.NewBlockNotification(order, number, header.Hash(), location)
+	if err := m.config.BlockWebhook.Post(notification); err != nil {
+		log.Warnln("Block webhook failed: ", err)
+	}
+}
+
+// resultLoop takes in the result and passes to the proper channels for receiving.
+func (m *Miner) resultLoop() {
+	for {
+		select {
+		case header := <-m.resultCh:
+			m.submissions.Add(1)
+			// Check the solution from scratch, so a header mutated after
+			// sealing is caught here instead of rejected upstream.
+			report := util.VerifySolution(m.engine, header)
+			if err := report.Problem(); err != nil {
+				atomic.AddUint64(&m.invalidSolutions, 1)
+				log.WithFields(log.Fields{"sealHash": report.SealHash, "powHash": report.PowHash, "nonce": header.NonceU64()}).Errorln("Engine produced an invalid solution, not submitting it: ", err)
+				m.submissions.Done()
+				continue
+			}
+			if !m.submitted.Add(header.SealHash()) {
+				atomic.AddUint64(&m.duplicateSolutions, 1)
+				log.WithFields(log.Fields{"sealHash": header.SealHash(), "nonce": header.NonceU64()}).Debugln("Dropping duplicate solution, its work was already submitted")
+				m.submissions.Done()
+				continue
+			}
+			order := report.Order
+			m.tracer.Solved(header, order)
+			atomic.AddUint64(&m.blocksFound[order], 1)
+			m.dashboard.AddBlock(util.DashboardBlock{Time: time.Now(), Order: order, Number: header.NumberArray(), Hash: header.Hash()})
+			sealing := time.Since(time.Unix(0, atomic.LoadInt64(&m.sealStart)))
+			if err := m.history.RecordBlock(header, order, sealing); err != nil {
+				log.Warnln("Unable to record block in history: ", err)
+			}
+			if err := m.blocks.Record(header, order); err != nil {
+				log.Warnln("Unable to write block to blocks file: ", err)
+			}
+			util.LogEvent(util.EventSolution, util.EventFields{"sealHash": header.SealHash(), "hash": header.Hash(), "nonce": header.NonceU64(), "order": order})
+			go m.fireHook(m.config.Hooks.OnBlockFound, hookEvent{Event: "on_block_found", Block: &hookBlock{
+				Order:    order,
+				Number:   header.NumberArray(),
+				Hash:     header.Hash(),
+				SealHash: header.SealHash(),
+				Nonce:    header.NonceU64(),
+			}})
+			if m.config.BlockWebhook.Notifies(order) {
+				go m.notifyBlock(header, order)
+			}
+			if m.config.BalanceInterval > 0 && !m.config.DryRun {
+				m.recordLedger(header)
+			}
+			switch {
+			case m.config.DryRun:
+				log.WithFields(log.Fields{"order": order, "sealHash": header.SealHash(), "nonce": header.NonceU64()}).Infoln("Dry run, not submitting solution", header.Hash())
+			case m.config.Proxy:
 				// Proxy miner only needs to send to the proxy (stored at zone context).
-				go m.sendMinedHeaderProxy(header)
+				m.rewardLock.Lock()
+				if m.devFeeActive {
+					atomic.AddUint64(&m.devFeeBlocks, 1)
+				} else if m.tenants != nil {
+					m.tenants.AddBlock(order)
+				}
+				m.rewardLock.Unlock()
+				m.submissions.Add(1)
+				go func() {
+					defer m.submissions.Done()
+					err := m.sendMinedHeaderProxy(header)
+					m.recordSubmission(header, "proxy", err == nil, err)
+					if m.rotator != nil && m.config.RewardRotation != util.RotateJob {
+						m.rotateRewardAddress()
+					}
+				}()
+			case m.getworkClient != nil:
+				m.submissions.Add(1)
+				go func() {
+					defer m.submissions.Done()
+					m.sendMinedHeaderGetwork(header)
+				}()
+			default:
+				m.submissions.Add(1)
+				go func() {
+					defer m.submissions.Done()
+					m.submitToNodes(header, order)
+				}()
 			}
+			found := log.WithFields(log.Fields{"order": order, "number": header.NumberArray(), "hash": header.Hash()})
 			switch order {
 			case common.PRIME_CTX:
-				log.Println(color.Ize(color.Red, "PRIME block : "), header.NumberArray(), header.Hash())
+				found.Infoln(color.Ize(color.Red, "PRIME block : "), header.NumberArray(), header.Hash())
 			case common.REGION_CTX:
-				log.Println(color.Ize(color.Yellow, "REGION block: "), header.NumberArray(), header.Hash())
+				found.Infoln(color.Ize(color.Yellow, "REGION block: "), header.NumberArray(), header.Hash())
 			case common.ZONE_CTX:
-				log.Println(color.Ize(color.Blue, "ZONE block  : "), header.NumberArray(), header.Hash())
+				found.Infoln(color.Ize(color.Blue, "ZONE block  : "), header.NumberArray(), header.Hash())
 			}
+			m.submissions.Done()
 		}
 	}
 }
 
 // Sends the mined header to the proxy.
 func (m *Miner) sendMinedHeaderProxy(header *types.Header) error {
-	retryDelay := 1 // Start retry at 1 second
-	for {
-		header_req, err := jsonrpc.MakeRequest(int(m.incrementLatestID()), "quai_receiveMinedHeader", header.RPCMarshalHeader())
+	backoff := m.profile.SubmitBackoff()
+	deadline := time.Now().Add(proxySubmitWindow)
+	params := []interface{}{m.proxy().MarshalHeader(header)}
+	if m.signer != nil {
+		signature, err := m.signer.SignHash(header.Hash())
 		if err != nil {
-			log.Fatalf("Could not create json message with header: %v", err)
+			log.Errorf("Unable to sign mined header: %v", err)
 			return err
 		}
-
-		err = m.proxyClient.SendTCPRequest(*header_req)
+		params = append(params, signature)
+	}
+	var call *util.Call
+	m.tracer.Submitted(header.SealHash(), "proxy")
+	for {
+		util.CaptureSubmission("proxy", header)
+		var err error
+		if util.ChaosFault(util.ChaosSubmit) {
+			err = util.ErrChaos
+		} else {
+			call, err = m.proxy().Call("quai_receiveMinedHeader", params...)
+		}
 		if err != nil {
-			log.Printf("Unable to send pending header to node: %v", err)
-			time.Sleep(time.Duration(retryDelay) * time.Second)
-			retryDelay *= 2
-			if retryDelay > maxRetryDelay {
-				retryDelay = maxRetryDelay
+			atomic.AddUint64(&m.submitErrors, 1)
+		} else {
+			util.LogEvent(util.EventSubmit, util.EventFields{"sealHash": header.SealHash(), "context": "proxy", "id": call.ID})
+		}
+		if err != nil && m.spool != nil {
+			log.Warnf("Unable to send mined header, spooling it: %v", err)
+			if err := m.spool.Add(params); err != nil {
+				log.Errorf("Unable to spool mined header: %v", err)
 			}
+			return err
+		}
+		if err != nil && time.Now().After(deadline) {
+			log.Warnf("Unable to send mined header, dropping it as stale: %v", err)
+			return err
+		}
+		if err != nil {
+			log.Warnf("Unable to send pending header to node: %v", err)
+			time.Sleep(backoff.Next())
 		} else {
 			break
 		}
-		log.Println("Sent mined header")
 	}
+	log.Debugln("Sent mined header, waiting for the proxy's answer")
+	resp, err := call.Wait(proxyAckTimeout)
+	if err == nil && resp.Result != nil && string(*resp.Result) == "false" {
+		err = errors.New("rejected")
+	}
+	// No reply leaves the outcome unknown, so only answers are counted.
+	if resp != nil {
+		m.countSubmission(header, err)
+	}
+	m.tracer.Acked(header.SealHash(), "proxy", err)
+	if err != nil {
+		atomic.AddUint64(&m.submitErrors, 1)
+		log.WithField("sealHash", header.SealHash()).Warnln("Proxy did not accept solution for", header.SealHash(), "err", err)
+		return err
+	}
+	log.WithField("sealHash", header.SealHash()).Infoln("Proxy accepted solution for", header.SealHash())
 	return nil
 }
 
+// Sends the mined header's nonce and mix digest to the getwork endpoint.
+func (m *Miner) sendMinedHeaderGetwork(header *types.Header) {
+	util.LogEvent(util.EventSubmit, util.EventFields{"sealHash": header.SealHash(), "context": "getwork"})
+	util.CaptureSubmission("getwork", header)
+	m.tracer.Submitted(header.SealHash(), "getwork")
+	accepted, err := false, util.ErrChaos
+	if !util.ChaosFault(util.ChaosSubmit) {
+		accepted, err = m.getworkClient.SubmitWork(header)
+	}
+	m.logAck(header, "getwork", accepted, err)
+	outcome := err
+	if err == nil && !accepted {
+		outcome = errors.New("rejected")
+	}
+	m.countSubmission(header, outcome)
+	m.tracer.Acked(header.SealHash(), "getwork", outcome)
+	if err != nil {
+		log.Warnln("Unable to submit work: ", err)
+	} else if !accepted {
+		log.Warnln("Getwork endpoint rejected solution for", header.SealHash())
+	}
+}
+
+// logAck records the outcome of a submission and counts the failures. Proxy
+// replies are recorded as they arrive by the proxy session.
+func (m *Miner) logAck(header *types.Header, target interface{}, accepted bool, err error) {
+	if !accepted {
+		atomic.AddUint64(&m.submitErrors, 1)
+	}
+	fields := util.EventFields{"sealHash": header.SealHash(), "context": target, "accepted": accepted}
+	if err != nil {
+		fields["error"] = err.Error()
+	}
+	util.LogEvent(util.EventAck, fields)
+	if ctx, ok := target.(int); ok {
+		target = util.ContextName(ctx)
+	}
+	m.recordSubmission(header, fmt.Sprint(target), accepted, err)
+}
+
+// countSubmission counts the final outcome of a submission, nil err for
+// accepted. A rejection is stale if it blames outdated work or newer work had
+// already arrived when it came back.
+func (m *Miner) countSubmission(header *types.Header, err error) {
+	if err == nil {
+		atomic.AddUint64(&m.submitAccepts, 1)
+		return
+	}
+	m.statsLock.Lock()
+	newer := m.workNumber[common.ZONE_CTX] > header.NumberU64(common.ZONE_CTX)
+	m.statsLock.Unlock()
+	if newer || util.IsStaleRejection(err) {
+		atomic.AddUint64(&m.submitStale, 1)
+	} else {
+		atomic.AddUint64(&m.submitRejects, 1)
+	}
+}
+
+// submissionStats reads the submission outcome counters.
+func (m *Miner) submissionStats() util.SubmissionStats {
+	return util.SubmissionStats{
+		Accepted: atomic.LoadUint64(&m.submitAccepts),
+		Rejected: atomic.LoadUint64(&m.submitRejects),
+		Stale:    atomic.LoadUint64(&m.submitStale),
+	}
+}
+
+// recordSubmission adds the outcome of a submission to the history.
+func (m *Miner) recordSubmission(header *types.Header, target string, accepted bool, err error) {
+	if err := m.history.RecordSubmission(header, target, accepted, err); err != nil {
+		log.Warnln("Unable to record submission in history: ", err)
+	}
+}
+
+// submitToNodes submits a block to every context it is valid in, from its
+// order down to the zone, concurrently, and reports contexts that still
+// failed after the retries.
+func (m *Miner) submitToNodes(header *types.Header, order int) {
+	errs := make([]error, common.HierarchyDepth)
+	var wg sync.WaitGroup
+	for ctx := order; ctx < common.HierarchyDepth; ctx++ {
+		wg.Add(1)
+		go func(ctx int) {
+			defer wg.Done()
+			errs[ctx] = m.submitToNode(ctx, header)
+		}(ctx)
+	}
+	wg.Wait()
+	if m.config.TestDifficulty > 0 {
+		// The node recomputes the difficulty test sealing rewrote and rejects
+		// the block, so the pipeline only runs up to its answer.
+		log.WithFields(log.Fields{"hash": header.Hash(), "errors": errs[order:]}).Infoln("Test difficulty block submitted, the nodes answered", errs[order:])
+		return
+	}
+	var accepted, failed []string
+	var firstErr error
+	for ctx := order; ctx < common.HierarchyDepth; ctx++ {
+		if errs[ctx] != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", util.ContextName(ctx), errs[ctx]))
+			if firstErr == nil {
+				firstErr = errs[ctx]
+			}
+		} else {
+			accepted = append(accepted, util.ContextName(ctx))
+		}
+	}
+	// A block taken by any of its contexts counts as accepted; partial
+	// submissions are counted on their own below.
+	if len(accepted) > 0 {
+		m.countSubmission(header, nil)
+	} else {
+		m.countSubmission(header, firstErr)
+	}
+	if len(failed) == 0 {
+		return
+	}
+	fields := log.Fields{"hash": header.Hash(), "accepted": accepted, "failed": failed}
+	if len(accepted) > 0 {
+		atomic.AddUint64(&m.partialSubmissions, 1)
+		log.WithFields(fields).Errorln("Block only partially submitted, accepted by", accepted, "failed at", failed)
+	} else {
+		log.WithFields(fields).Errorln("Block not accepted by any node:", failed)
+	}
+}
+
+// submitToNode submits a block to one context's node, retrying with backoff.
+func (m *Miner) submitToNode(ctx int, header *types.Header) (err error) {
+	backoff := m.profile.SubmitBackoff()
+	m.tracer.Submitted(header.SealHash(), util.ContextName(ctx))
+	defer func() { m.tracer.Acked(header.SealHash(), util.ContextName(ctx), err) }()
+	for attempt := 0; ; attempt++ {
+		util.LogEvent(util.EventSubmit, util.EventFields{"sealHash": header.SealHash(), "context": ctx})
+		util.CaptureSubmission(fmt.Sprint("context ", ctx), header)
+		err := m.sendMinedHeaderNodes(ctx, header)
+		if m.config.TestDifficulty > 0 {
+			// Rejected by design, see submitToNodes, so neither retried nor
+			// counted as a failure.
+			m.recordSubmission(header, util.ContextName(ctx), err == nil, err)
+			return err
+		}
+		m.logAck(header, ctx, err == nil, err)
+		if err == nil || attempt == nodeSubmitRetries {
+			return err
+		}
+		delay := backoff.Next()
+		log.Warnf("Error submitting block to %s node, retrying in %s: %v", util.ContextName(ctx), delay.Round(time.Millisecond), err)
+		select {
+		case <-time.After(delay):
+		case <-m.quit:
+			return err
+		}
+	}
+}
+
 // Sends the mined header to its mining client.
 func (m *Miner) sendMinedHeaderNodes(order int, header *types.Header) error {
+	if util.ChaosFault(util.ChaosSubmit) {
+		return util.ErrChaos
+	}
 	return m.sliceClients[order].ReceiveMinedHeader(context.Background(), header)
 }
-
-// Used for sequencing JSON RPC messages.
-func (m *Miner) incrementLatestID() uint64 {
-	cur := m.latestId
-	m.latestId += 1
-	return cur
-}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package util
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// ExcludeCores removes the given cores from the CPU affinity of every thread
+// in the process and returns the number of cores left to mine on.
+func ExcludeCores(cores []int) (int, error) {
+	if err := CheckCores(cores); err != nil {
+		return 0, err
+	}
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return 0, err
+	}
+	for _, core := range cores {
+		set.Clear(core)
+	}
+	if set.Count() == 0 {
+		return 0, errors.New("core mask excludes every available core")
+	}
+	return set.Count(), forEachThread(func(tid int) error {
+		return unix.SchedSetaffinity(tid, &set)
+	})
+}
+
+// PinCores restricts every thread in the process to exactly the given cores
+// and returns how many of them are usable. The set is shared, sealing threads
+// aren't tied to one core each.
+func PinCores(cores []int) (int, error) {
+	if err := CheckCores(cores); err != nil {
+		return 0, err
+	}
+	var set unix.CPUSet
+	for _, core := range cores {
+		set.Set(core)
+	}
+	if set.Count() == 0 {
+		return 0, errors.New("no cores to pin to")
+	}
+	return set.Count(), forEachThread(func(tid int) error {
+		return unix.SchedSetaffinity(tid, &set)
+	})
+}
+
+// CheckCores rejects cores outside the process's current affinity set, whose
+// ids in a cpuset or container need not start at 0.
+func CheckCores(cores []int) error {
+	var allowed unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &allowed); err != nil {
+		return err
+	}
+	for _, core := range cores {
+		if core < 0 || !allowed.IsSet(core) {
+			return fmt.Errorf("core %d is not available to the process", core)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !windows
+
+package util
+
+import "errors"
+
+// ExcludeCores is only supported on Linux.
+func ExcludeCores(cores []int) (int, error) {
+	return 0, errors.New("excluding cores is not supported on this platform")
+}
+
+// PinCores is only supported on Linux and Windows.
+func PinCores(cores []int) (int, error) {
+	return 0, errors.New("pinning cores is not supported on this platform")
+}
+
+// CheckCores accepts any cores, ExcludeCores and PinCores fail here anyway.
+func CheckCores(cores []int) error {
+	return nil
+}
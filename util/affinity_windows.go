@@ -0,0 +1,48 @@
+//go:build windows
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"golang.org/x/sys/windows"
+)
+
+var procSetProcessAffinityMask = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetProcessAffinityMask")
+
+// ExcludeCores is only supported on Linux.
+func ExcludeCores(cores []int) (int, error) {
+	return 0, errors.New("excluding cores is not supported on this platform")
+}
+
+// PinCores restricts the process to exactly the given cores and returns how
+// many of them are usable. The mask is process-wide, sealing threads aren't
+// tied to one core each. Only the first processor group is addressable.
+func PinCores(cores []int) (int, error) {
+	var mask uintptr
+	for _, core := range cores {
+		if core < 0 || core >= bits.UintSize {
+			return 0, fmt.Errorf("core %d is outside the first processor group", core)
+		}
+		mask |= 1 << uint(core)
+	}
+	if mask == 0 {
+		return 0, errors.New("no cores to pin to")
+	}
+	if ok, _, err := procSetProcessAffinityMask.Call(uintptr(windows.CurrentProcess()), mask); ok == 0 {
+		return 0, err
+	}
+	return bits.OnesCount64(uint64(mask)), nil
+}
+
+// CheckCores rejects cores outside the first processor group.
+func CheckCores(cores []int) error {
+	for _, core := range cores {
+		if core < 0 || core >= bits.UintSize {
+			return fmt.Errorf("core %d is outside the first processor group", core)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	c_Backoff_Multiplier = 2
+	c_Backoff_Jitter     = 0.2
+)
+
+// Jitter source, seeded per process so restarted miners don't share delays.
+var (
+	jitterLock sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Backoff spaces out reconnect and retry attempts. The first delay is Initial,
+// every later one Multiplier times the previous up to Max, and each is
+// randomized by up to Jitter of itself so miners that lost the same node don't
+// retry in lockstep.
+type Backoff struct {
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+	Jitter     float64
+
+	delay time.Duration
+}
+
+// NewBackoff returns a backoff from initial to max, doubling with 20% jitter.
+func NewBackoff(initial, max time.Duration) *Backoff {
+	return &Backoff{
+		Initial:    initial,
+		Multiplier: c_Backoff_Multiplier,
+		Max:        max,
+		Jitter:     c_Backoff_Jitter,
+	}
+}
+
+// Next returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	if b.delay == 0 {
+		b.delay = b.Initial
+	} else {
+		b.delay = time.Duration(float64(b.delay) * b.Multiplier)
+	}
+	if b.Max > 0 && b.delay > b.Max {
+		b.delay = b.Max
+	}
+	if b.Jitter <= 0 {
+		return b.delay
+	}
+	jitterLock.Lock()
+	r := jitterRand.Float64()
+	jitterLock.Unlock()
+	return time.Duration(float64(b.delay) * (1 + b.Jitter*(2*r-1)))
+}
+
+// Wait sleeps for the next delay. It returns false if quit closes first.
+func (b *Backoff) Wait(quit <-chan struct{}) bool {
+	select {
+	case <-time.After(b.Next()):
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// Reset starts over at Initial after a successful attempt.
+func (b *Backoff) Reset() {
+	b.delay = 0
+}
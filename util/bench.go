@@ -0,0 +1,60 @@
+package util
+
+import (
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+// Difficulty of benchmark work, high enough that no nonce solves it.
+var benchDifficulty = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// syntheticHeader returns mineable work at difficulty that belongs to no
+// chain, for running the pipeline without a node.
+func syntheticHeader(difficulty *big.Int, location common.Location) *types.Header {
+	header := types.EmptyHeader()
+	header.SetDifficulty(difficulty)
+	header.SetLocation(location)
+	header.SetTime(uint64(time.Now().Unix()))
+	for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+		header.SetNumber(big.NewInt(1), ctx)
+	}
+	return header
+}
+
+// Benchmark hashes synthetic work on the given number of threads for the
+// duration and returns the hashes per second. Every hash is the full PoW
+// computation, the same one the sealing loop runs per nonce, so the count is
+// exact rather than sampled from the engine's hashrate meter.
+func Benchmark(engine PowEngine, threads int, duration time.Duration) float64 {
+	work := syntheticHeader(benchDifficulty, common.Location{0, 0})
+	// The first hash of an epoch builds the verification cache; keep it out
+	// of the measurement.
+	engine.CalcOrder(types.CopyHeader(work))
+
+	var (
+		hashes uint64
+		wg     sync.WaitGroup
+	)
+	deadline := time.Now().Add(duration)
+	start := time.Now()
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(nonce uint64) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				header := types.CopyHeader(work)
+				header.SetNonce(types.EncodeNonce(nonce))
+				engine.CalcOrder(header)
+				atomic.AddUint64(&hashes, 1)
+				nonce++
+			}
+		}(uint64(i) << 48)
+	}
+	wg.Wait()
+	return float64(hashes) / time.Since(start).Seconds()
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"database/sql"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+const benchSchema = `
+CREATE TABLE IF NOT EXISTS bench (
+	run         INTEGER NOT NULL, -- unix milliseconds the run started
+	fingerprint TEXT NOT NULL,
+	backend     TEXT NOT NULL,
+	version     TEXT NOT NULL,
+	threads     INTEGER NOT NULL,
+	hashrate    REAL NOT NULL
+);
+CREATE INDEX IF NOT EXISTS bench_machine ON bench(fingerprint, backend, run);
+`
+
+// BenchDB is a SQLite record of bench runs, so a run can be compared with
+// the previous one on the same machine.
+type BenchDB struct {
+	db *sql.DB
+}
+
+// BenchRun is the hashrate a bench run measured at each thread count.
+type BenchRun struct {
+	Time        time.Time
+	Fingerprint string
+	Backend     string
+	Version     string
+	Hashrates   map[int]float64
+}
+
+// HardwareFingerprint identifies the machine bench results are comparable
+// on: the CPU model, core count, OS and architecture.
+func HardwareFingerprint() string {
+	return fmt.Sprintf("%s/%d cores/%s/%s", CPUModel(), runtime.NumCPU(), runtime.GOOS, runtime.GOARCH)
+}
+
+// OpenBenchDB opens the database at path, creating it if needed.
+func OpenBenchDB(path string) (*BenchDB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(benchSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BenchDB{db: db}, nil
+}
+
+// Close closes the database.
+func (b *BenchDB) Close() error {
+	return b.db.Close()
+}
+
+// Record stores a run.
+func (b *BenchDB) Record(run BenchRun) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for threads, hashrate := range run.Hashrates {
+		if _, err := tx.Exec(`INSERT INTO bench VALUES (?, ?, ?, ?, ?, ?)`,
+			run.Time.UnixMilli(), run.Fingerprint, run.Backend, run.Version, threads, hashrate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Previous returns the latest run with the fingerprint and backend, or nil if
+// there is none.
+func (b *BenchDB) Previous(fingerprint, backend string) (*BenchRun, error) {
+	var at sql.NullInt64
+	if err := b.db.QueryRow(`SELECT MAX(run) FROM bench WHERE fingerprint = ? AND backend = ?`, fingerprint, backend).Scan(&at); err != nil {
+		return nil, err
+	}
+	if !at.Valid {
+		return nil, nil
+	}
+	rows, err := b.db.Query(`SELECT version, threads, hashrate FROM bench WHERE run = ? AND fingerprint = ? AND backend = ?`, at.Int64, fingerprint, backend)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	run := &BenchRun{Time: time.UnixMilli(at.Int64), Fingerprint: fingerprint, Backend: backend, Hashrates: make(map[int]float64)}
+	for rows.Next() {
+		var (
+			threads  int
+			hashrate float64
+		)
+		if err := rows.Scan(&run.Version, &threads, &hashrate); err != nil {
+			return nil, err
+		}
+		run.Hashrates[threads] = hashrate
+	}
+	return run, rows.Err()
+}
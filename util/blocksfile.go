@@ -0,0 +1,61 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+// BlocksWriter appends a line per found block to a plain text file, newest
+// last, with a link to the block on a block explorer.
+type BlocksWriter struct {
+	mu       sync.Mutex
+	file     *os.File
+	explorer string
+}
+
+// OpenBlocksFile opens the blocks file for appending. explorerURL is the link
+// template, with {hash}, {number} (the zone number) and {location} (e.g.
+// cyprus1) replaced per block.
+func OpenBlocksFile(path, explorerURL string) (*BlocksWriter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &BlocksWriter{file: file, explorer: explorerURL}, nil
+}
+
+// Record appends the block found at order.
+func (w *BlocksWriter) Record(header *types.Header, order int) error {
+	if w == nil {
+		return nil
+	}
+	link := strings.NewReplacer(
+		"{hash}", header.Hash().Hex(),
+		"{number}", strconv.FormatUint(header.NumberU64(common.ZONE_CTX), 10),
+		"{location}", header.Location().Name(),
+	).Replace(w.explorer)
+	line := fmt.Sprintf("%s %-6s number %v hash %s difficulty %s location %s %s\n",
+		time.Now().UTC().Format(time.RFC3339), strings.ToUpper(ContextName(order)), header.NumberArray(),
+		header.Hash().Hex(), header.Difficulty(), header.Location().Name(), link)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.file.WriteString(line)
+	return err
+}
+
+// Close closes the file.
+func (w *BlocksWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
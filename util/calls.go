@@ -0,0 +1,113 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/INFURA/go-ethlibs/jsonrpc"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+)
+
+var (
+	errCallTimeout   = errors.New("no reply from proxy")
+	errSessionClosed = errors.New("proxy session closed")
+)
+
+// Call is a request sent on a MinerSession whose reply is awaited.
+type Call struct {
+	ID      uint64
+	Method  string
+	reply   chan *rpc.JsonRPCResponse
+	session *MinerSession
+}
+
+// Wait returns the proxy's reply to the call, an error if the proxy answered
+// with one, or errCallTimeout if nothing came back within timeout. A timed out
+// call stops waiting for its reply.
+func (c *Call) Wait(timeout time.Duration) (*rpc.JsonRPCResponse, error) {
+	select {
+	case resp, ok := <-c.reply:
+		if !ok {
+			return nil, errSessionClosed
+		}
+		if resp.Error != nil {
+			return resp, errors.New(resp.Error.Message)
+		}
+		return resp, nil
+	case <-time.After(timeout):
+		c.session.takePending(c.ID)
+		return nil, errCallTimeout
+	}
+}
+
+// nextID allocates a request ID unique to the session.
+func (ms *MinerSession) nextID() uint64 {
+	return atomic.AddUint64(&ms.latestId, 1)
+}
+
+// Send sends a request the caller doesn't wait on. Replies carrying work
+// still reach ListenTCP's update channel.
+func (ms *MinerSession) Send(method string, params ...interface{}) error {
+	msg, err := jsonrpc.MakeRequest(int(ms.nextID()), method, params...)
+	if err != nil {
+		return err
+	}
+	return ms.SendTCPRequest(*msg)
+}
+
+// Call sends a request and registers it so ListenTCP hands the matching reply
+// to the returned Call.
+func (ms *MinerSession) Call(method string, params ...interface{}) (*Call, error) {
+	call := &Call{ID: ms.nextID(), Method: method, reply: make(chan *rpc.JsonRPCResponse, 1), session: ms}
+	msg, err := jsonrpc.MakeRequest(int(call.ID), method, params...)
+	if err != nil {
+		return nil, err
+	}
+	ms.pendingLock.Lock()
+	if ms.pending == nil {
+		ms.pending = make(map[uint64]*Call)
+	}
+	ms.pending[call.ID] = call
+	ms.pendingLock.Unlock()
+	if err := ms.SendTCPRequest(*msg); err != nil {
+		ms.takePending(call.ID)
+		return nil, err
+	}
+	return call, nil
+}
+
+// takePending removes and returns the pending call with the given ID.
+func (ms *MinerSession) takePending(id uint64) *Call {
+	ms.pendingLock.Lock()
+	defer ms.pendingLock.Unlock()
+	call := ms.pending[id]
+	delete(ms.pending, id)
+	return call
+}
+
+// deliver hands a reply to the call awaiting it, if any, and returns that
+// call.
+func (ms *MinerSession) deliver(resp *rpc.JsonRPCResponse) *Call {
+	var id uint64
+	if json.Unmarshal(resp.ID, &id) != nil {
+		return nil
+	}
+	call := ms.takePending(id)
+	if call != nil {
+		call.reply <- resp
+	}
+	return call
+}
+
+// failPending ends every pending call once the session is gone.
+func (ms *MinerSession) failPending() {
+	ms.pendingLock.Lock()
+	defer ms.pendingLock.Unlock()
+	for id, call := range ms.pending {
+		close(call.reply)
+		delete(ms.pending, id)
+	}
+}
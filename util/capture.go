@@ -0,0 +1,290 @@
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+const c_Redacted = "REDACTED"
+
+// Capture directions.
+const (
+	CaptureInbound  = "in"
+	CaptureOutbound = "out"
+)
+
+// CaptureRecord is one captured message in a support bundle's capture.jsonl.
+type CaptureRecord struct {
+	Direction string                 `json:"dir"`
+	Kind      string                 `json:"kind"`
+	Target    string                 `json:"target,omitempty"`
+	Time      time.Time              `json:"time"`
+	Mono      int64                  `json:"mono"`
+	Header    map[string]interface{} `json:"header"`
+}
+
+// CaptureEnvironment describes the host a bundle was captured on.
+type CaptureEnvironment struct {
+	Version    string    `json:"version"`
+	GoVersion  string    `json:"goVersion"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	NumCPU     int       `json:"numCpu"`
+	GOMAXPROCS int       `json:"gomaxprocs"`
+	CPUModel   string    `json:"cpuModel"`
+	Args       []string  `json:"args"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+}
+
+// capture spools records to a temporary file until the bundle is written, so
+// long captures don't grow the heap. A recording appends the same records to
+// its own file.
+var capture struct {
+	sync.Mutex
+	path  string
+	spool *os.File
+	enc   *json.Encoder
+	start time.Time
+
+	record      *os.File
+	recordEnc   *json.Encoder
+	recordStart time.Time
+}
+
+// StartCapture begins recording work and submissions for a support bundle
+// written to path by FinishCapture.
+func StartCapture(path string) error {
+	spool, err := os.CreateTemp("", "quai-cpu-miner-capture-*.jsonl")
+	if err != nil {
+		return err
+	}
+	capture.Lock()
+	defer capture.Unlock()
+	capture.path = path
+	capture.spool = spool
+	capture.enc = json.NewEncoder(spool)
+	capture.start = time.Now()
+	return nil
+}
+
+// StartRecording appends every work package and submission to path as JSON
+// lines of capture records, the stream LoadRecording reads back for replay.
+func StartRecording(path string) error {
+	record, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	capture.Lock()
+	defer capture.Unlock()
+	capture.record = record
+	capture.recordEnc = json.NewEncoder(record)
+	capture.recordStart = time.Now()
+	return nil
+}
+
+// StopRecording closes the recording file.
+func StopRecording() error {
+	capture.Lock()
+	defer capture.Unlock()
+	if capture.record == nil {
+		return nil
+	}
+	record := capture.record
+	capture.record, capture.recordEnc = nil, nil
+	return record.Close()
+}
+
+// CaptureWork records inbound work.
+func CaptureWork(header *types.Header) {
+	captureHeader(CaptureInbound, "work", "", header)
+}
+
+// CaptureSubmission records a solution sent to target.
+func CaptureSubmission(target string, header *types.Header) {
+	captureHeader(CaptureOutbound, "submit", target, header)
+}
+
+func captureHeader(direction, kind, target string, header *types.Header) {
+	capture.Lock()
+	defer capture.Unlock()
+	if capture.enc == nil && capture.recordEnc == nil {
+		return
+	}
+	now := time.Now()
+	record := CaptureRecord{
+		Direction: direction,
+		Kind:      kind,
+		Target:    target,
+		Time:      now.UTC(),
+		Header:    header.RPCMarshalHeader(),
+	}
+	if capture.enc != nil {
+		record.Mono = now.Sub(capture.start).Nanoseconds()
+		capture.enc.Encode(record)
+	}
+	if capture.recordEnc != nil {
+		record.Mono = now.Sub(capture.recordStart).Nanoseconds()
+		capture.recordEnc.Encode(record)
+	}
+}
+
+// FinishCapture stops recording and writes the bundle: the redacted config,
+// the host environment and the captured messages in a gzipped tarball.
+func FinishCapture(config Config, version string) error {
+	capture.Lock()
+	defer capture.Unlock()
+	if capture.spool == nil {
+		return nil
+	}
+	spool := capture.spool
+	capture.spool, capture.enc = nil, nil
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	env := CaptureEnvironment{
+		Version:    version,
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		CPUModel:   CPUModel(),
+		Args:       os.Args,
+		Started:    capture.start.UTC(),
+		Finished:   time.Now().UTC(),
+	}
+	configJSON, err := json.MarshalIndent(RedactConfig(config), "", "  ")
+	if err != nil {
+		return err
+	}
+	envJSON, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(capture.path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, file := range []struct {
+		name string
+		body []byte
+	}{{"config.json", configJSON}, {"environment.json", envJSON}} {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.body)), ModTime: env.Finished}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.body); err != nil {
+			return err
+		}
+	}
+	info, err := spool.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "capture.jsonl", Mode: 0644, Size: info.Size(), ModTime: env.Finished}); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, spool); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// RedactConfig blanks passwords, tokens and secrets, and strips credentials
+// from endpoint URLs, so the config can be shared.
+func RedactConfig(config Config) Config {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = c_Redacted
+		}
+	}
+	redact(&config.Password)
+	redact(&config.KeystorePassword)
+	redact(&config.PayloadSecret)
+	redact(&config.ControlToken)
+	redact(&config.SNMPCommunity)
+	redact(&config.Influx.Token)
+	// Chat webhook URLs are the credential themselves.
+	redact(&config.BlockWebhook.URL)
+
+	config.OutboundProxy = redactURL(config.OutboundProxy)
+	config.ProxyURL = redactURL(config.ProxyURL)
+	config.ProxyURLs = append([]string(nil), config.ProxyURLs...)
+	for i := range config.ProxyURLs {
+		config.ProxyURLs[i] = redactURL(config.ProxyURLs[i])
+	}
+	config.PrimeURL = redactURL(config.PrimeURL)
+	config.RegionURLs = append([]string(nil), config.RegionURLs...)
+	for i := range config.RegionURLs {
+		config.RegionURLs[i] = redactURL(config.RegionURLs[i])
+	}
+	zoneURLs := make([][]string, len(config.ZoneURLs))
+	for i, urls := range config.ZoneURLs {
+		for _, u := range urls {
+			zoneURLs[i] = append(zoneURLs[i], redactURL(u))
+		}
+	}
+	config.ZoneURLs = zoneURLs
+	config.GetworkURL = redactURL(config.GetworkURL)
+	config.Telemetry.URL = redactURL(config.Telemetry.URL)
+	config.Influx.URL = redactURL(config.Influx.URL)
+	config.Tracing.Endpoint = redactURL(config.Tracing.Endpoint)
+	if config.Tracing.Headers != nil {
+		headers := make(map[string]string, len(config.Tracing.Headers))
+		for name := range config.Tracing.Headers {
+			headers[name] = c_Redacted
+		}
+		config.Tracing.Headers = headers
+	}
+	for _, hook := range []*Hook{&config.Cooling.OnHigh, &config.Cooling.OnLow, &config.Cooling.OnStart, &config.Cooling.OnStop,
+		&config.Hooks.OnStart, &config.Hooks.OnBlockFound, &config.Hooks.OnDisconnect, &config.Hooks.OnShutdown, &config.Hooks.OnLowHashrate} {
+		hook.URL = redactURL(hook.URL)
+	}
+	return config
+}
+
+// redactURL drops user info and query strings, where API keys usually live.
+// Keys embedded in the path are replaced too when the path looks like one.
+func redactURL(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	if u.User != nil {
+		u.User = url.User(c_Redacted)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = c_Redacted
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if len(segment) >= 20 {
+			segments[i] = c_Redacted
+		}
+	}
+	u.Path = strings.Join(segments, "/")
+	return u.String()
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Faults injected in chaos mode.
+const (
+	ChaosDrop    = "drop"    // close the proxy connection
+	ChaosDelay   = "delay"   // hold back work before it reaches the sealer
+	ChaosCorrupt = "corrupt" // garble a message from the proxy
+	ChaosSubmit  = "submit"  // fail a submission before it is sent
+)
+
+// Longest delay injected into work delivery.
+const c_Chaos_Max_Delay = 5 * time.Second
+
+// ErrChaos is the error of an injected failure.
+var ErrChaos = errors.New("injected fault")
+
+// chaos holds the fault injector, off unless EnableChaos was called.
+var chaos struct {
+	sync.Mutex
+	rate float64
+	rng  *rand.Rand
+}
+
+// EnableChaos injects every kind of fault with probability rate at each
+// chance, to soak test the reconnection and retry paths.
+func EnableChaos(rate float64) {
+	chaos.Lock()
+	defer chaos.Unlock()
+	chaos.rate = rate
+	chaos.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+// ChaosFault reports whether to inject the fault now, logging it when so.
+func ChaosFault(kind string) bool {
+	chaos.Lock()
+	defer chaos.Unlock()
+	if chaos.rng == nil || chaos.rng.Float64() >= chaos.rate {
+		return false
+	}
+	log.WithField("fault", kind).Warnln("Chaos: injecting", kind, "fault")
+	return true
+}
+
+// ChaosLatency is a random delay for an injected ChaosDelay fault.
+func ChaosLatency() time.Duration {
+	chaos.Lock()
+	defer chaos.Unlock()
+	if chaos.rng == nil {
+		return 0
+	}
+	return time.Duration(chaos.rng.Int63n(int64(c_Chaos_Max_Delay)))
+}
+
+// chaosCorrupt garbles a copy of data when a ChaosCorrupt fault is due.
+func chaosCorrupt(data []byte) []byte {
+	if len(data) == 0 || !ChaosFault(ChaosCorrupt) {
+		return data
+	}
+	chaos.Lock()
+	defer chaos.Unlock()
+	garbled := append([]byte(nil), data...)
+	garbled[chaos.rng.Intn(len(garbled))] ^= 0xff
+	return garbled
+}
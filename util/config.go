@@ -1,6 +1,9 @@
 package util
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/dominant-strategies/go-quai/common"
 	"github.com/spf13/viper"
 )
@@ -9,21 +12,123 @@ import (
 type Config struct {
 	RewardAddress string
 	Password      string
+	Network       string
 	Proxy         bool
 	ProxyURL      string
+	ProxyURLs     []string
+	OutboundProxy string
 	PrimeURL      string
 	RegionURLs    []string
 	ZoneURLs      [][]string
 	Location      common.Location
+	Locations     []ZoneLocation
+	Discovery     Discovery
+	LowMemory     bool
+	ExcludeCores  []int
+	CPUAffinity   []int
+	Cooling       CoolingHooks
+	Hooks         LifecycleHooks
+	BlockWebhook  BlockWebhook
+
+	PerformanceGovernor bool
+
+	Listeners     Listeners
+	ControlAddr   string // deprecated alias of Listeners.Control.Addr
+	ControlToken  string
+	SNMPCommunity string
+
+	Guardrails Guardrails
+	Idle       IdleMining
+	AutoTune   AutoTune
+	Telemetry  Telemetry
+	Influx     Influx
+	Tracing    Tracing
+
+	Preset     string
+	Threads    int
+	CPUPercent float64
+
+	GetworkURL      string
+	GetworkInterval int
+
+	Engines []Fork
+
+	Keystore         string
+	KeystorePassword string
+
+	ProxyTLS     TLSConfig
+	ProxyDialect string
+	Connectivity string
+	SpoolFile    string
+	SnapshotFile string
+
+	ProxyWorkTimeout int
+	HashrateInterval int
+	RigID            string
+	MaxWorkAge       int
+	ReadyWorkAge     int
+	SyncCheck        SyncCheck
+	Timestamp        Timestamp
+	TimeRollInterval int
+	TipCheckInterval int
+
+	PayloadSecret string
+
+	RewardAddresses     []WeightedAddress
+	RewardRotation      string // RotateBlock (default) or RotateJob
+	ZoneRewardAddresses []ZoneRewardAddress
+	Tenants             []Tenant
+	TenantInterval      int
+	BalanceInterval     int
+	DevFee              DevFee
+
+	TestDifficulty int64
+	DryRun         bool
+
+	EventLog   string
+	HistoryDB  string
+	StatsFile  StatsFile
+	BlocksFile BlocksFile
+
+	LogLevel  string
+	LogFormat string
+
+	Policy PolicyConfig
 }
 
-// LoadConfig reads configuration from file or environment variables.
+// CoolingHooks fire external actions on the miner's thermal state. OnHigh
+// fires when the CPU reaches HighTemp and OnLow once it has cooled back down
+// to LowTemp. Action protects the CPU itself while it is hot: CoolingPause
+// stops sealing and CoolingThrottle halves the threads. OnStart and OnStop are
+// deprecated aliases of Hooks.OnStart and Hooks.OnShutdown.
+type CoolingHooks struct {
+	HighTemp float64
+	LowTemp  float64
+	Action   string
+	OnHigh   Hook
+	OnLow    Hook
+	OnStart  Hook
+	OnStop   Hook
+}
+
+// Cooling actions taken while the CPU is above HighTemp.
+const (
+	CoolingPause    = "pause"
+	CoolingThrottle = "throttle"
+)
+
+// LoadConfig reads configuration from the file at path, or when path is empty
+// from config/config.yaml or ./config.yaml.
 func LoadConfig(path string) (config Config, err error) {
-	viper.AddConfigPath("./config")
-	viper.SetConfigName("config") // name of config file (without extension)
-	viper.SetConfigType("yaml")   // REQUIRED if the config file does not have the extension in the name
-	viper.AddConfigPath(".")      // optionally look for config in the working directory
-	err = viper.ReadInConfig()    // Find and read the config file
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.AddConfigPath("./config")
+		viper.SetConfigName("config") // name of config file (without extension)
+		viper.AddConfigPath(".")      // optionally look for config in the working directory
+	}
+	viper.SetConfigType("yaml") // REQUIRED if the config file does not have the extension in the name
+	err = viper.ReadInConfig()  // Find and read the config file
 
 	if err != nil { // Handle errors reading the config file
 		return config, err
@@ -32,3 +137,191 @@ func LoadConfig(path string) (config Config, err error) {
 	err = viper.Unmarshal(&config)
 	return config, err
 }
+
+// MigrateDeprecated moves settings from deprecated keys to the ones replacing
+// them, returning a warning for every deprecated key in use.
+func (c *Config) MigrateDeprecated() ([]string, error) {
+	var warnings []string
+	for _, alias := range []struct {
+		old, current *Hook
+		oldName      string
+		currentName  string
+	}{
+		{&c.Cooling.OnStart, &c.Hooks.OnStart, "Cooling.OnStart", "Hooks.OnStart"},
+		{&c.Cooling.OnStop, &c.Hooks.OnShutdown, "Cooling.OnStop", "Hooks.OnShutdown"},
+	} {
+		if !alias.old.Enabled() {
+			continue
+		}
+		if alias.current.Enabled() {
+			return warnings, fmt.Errorf("%s is deprecated and can't be combined with %s", alias.oldName, alias.currentName)
+		}
+		*alias.current, *alias.old = *alias.old, Hook{}
+		warnings = append(warnings, fmt.Sprintf("%s is deprecated, use %s, whose events are named on_start and on_shutdown", alias.oldName, alias.currentName))
+	}
+	if c.ControlAddr != "" {
+		if c.Listeners.Control.Enabled() {
+			return warnings, errors.New("ControlAddr is deprecated and can't be combined with Listeners.Control.Addr")
+		}
+		c.Listeners.Control.Addr, c.ControlAddr = c.ControlAddr, ""
+		warnings = append(warnings, "ControlAddr is deprecated, use Listeners.Control.Addr")
+	}
+	return warnings, nil
+}
+
+// ProxyList returns the proxies in priority order: ProxyURL, then the
+// failover ProxyURLs.
+func (c Config) ProxyList() []string {
+	var urls []string
+	if c.ProxyURL != "" {
+		urls = append(urls, c.ProxyURL)
+	}
+	return append(urls, c.ProxyURLs...)
+}
+
+// ZoneRewardAddress is the reward address used when mining Location. Quai
+// addresses belong to a zone, so each zone needs its own.
+type ZoneRewardAddress struct {
+	Location common.Location
+	Address  string
+}
+
+// RewardAddressFor returns the reward address configured for location,
+// falling back to RewardAddress. It only reaches the chain through a proxy
+// login.
+func (c Config) RewardAddressFor(location common.Location) string {
+	for _, zone := range c.ZoneRewardAddresses {
+		if zone.Location.Equal(location) {
+			return zone.Address
+		}
+	}
+	return c.RewardAddress
+}
+
+// ZoneLocation is a location mined alongside the others in one process,
+// getting its Weight share of the sealing threads.
+type ZoneLocation struct {
+	Location common.Location
+	Weight   int
+}
+
+// DevFee optionally mines to a donation address for Percent of every Period
+// seconds. It is off unless Percent is set.
+type DevFee struct {
+	Percent float64
+	Address string
+	Period  int
+}
+
+// LifecycleHooks run user integrations on miner lifecycle events, each with a
+// JSON payload describing the event. OnLowHashrate fires when the hashrate
+// drops below MinHashrate (h/s) while mining.
+type LifecycleHooks struct {
+	OnStart       Hook
+	OnBlockFound  Hook
+	OnDisconnect  Hook
+	OnShutdown    Hook
+	OnLowHashrate Hook
+	MinHashrate   float64
+}
+
+// PolicyConfig points at a Starlark policy script evaluated every Interval
+// seconds.
+type PolicyConfig struct {
+	Script   string
+	Interval int
+}
+
+// BlocksFile appends a line per found block to Path, empty disables, with a
+// link built from the ExplorerURL template: {hash}, {number} and {location}
+// are replaced with the block's hash, zone number and location name.
+type BlocksFile struct {
+	Path        string
+	ExplorerURL string
+}
+
+// StatsFile appends a snapshot of the session stats to Path every Interval
+// seconds, in csv or json lines Format (by default from the extension).
+type StatsFile struct {
+	Path     string
+	Format   string
+	Interval int
+}
+
+// SyncCheck is what the miner does about nodes that haven't synced when it
+// starts in node mode: wait for them with Wait, else warn and mine anyway.
+// A node whose chain head is older than MaxHeadAge seconds counts as behind,
+// 0 leaves the head age unchecked.
+type SyncCheck struct {
+	Wait       bool
+	MaxHeadAge int
+}
+
+// Timestamp is how sealed headers are stamped: TimestampOn (the default)
+// with the rig clock, TimestampOff keeping the node's timestamp, or
+// TimestampClamp with the rig clock held within MaxDrift seconds of the
+// node's. NTPServer, when set, is asked for the clock skew at startup.
+type Timestamp struct {
+	Mode      string
+	MaxDrift  int
+	NTPServer string
+}
+
+// Timestamp modes.
+const (
+	TimestampOn    = "on"
+	TimestampOff   = "off"
+	TimestampClamp = "clamp"
+)
+
+// Guardrails cap the miner's share of the host. MaxCPUPercent limits the mining
+// threads to a share of the cores, MaxLoad is the per-core system load above
+// which threads are shed, and MaxMemoryMB is a soft limit on the miner's heap.
+type Guardrails struct {
+	MaxCPUPercent float64
+	MaxLoad       float64
+	MaxMemoryMB   int64
+}
+
+// IdleMining only mines while the desktop is idle: sealing pauses on user
+// input and resumes once there has been none for After seconds, 0 disables.
+// Where the input idle time can't be read, such as on headless hosts or from
+// the Windows service session, the host counts as idle while the per-core load
+// of everything but the miner stays below MaxLoad.
+type IdleMining struct {
+	After   int
+	MaxLoad float64
+}
+
+// Influx pushes the miner's metrics to an InfluxDB or VictoriaMetrics write
+// endpoint every Interval seconds, empty URL disables. Token is sent as an
+// InfluxDB 2.x API token. Tags are added to the rig and location tags.
+type Influx struct {
+	URL      string
+	Token    string
+	Interval int
+	Tags     map[string]string
+}
+
+// Tracing exports OpenTelemetry traces of the work lifecycle to an OTLP/HTTP
+// traces endpoint, empty disables. Headers are sent with every export, e.g. for
+// a collector's API key.
+type Tracing struct {
+	Endpoint string
+	Headers  map[string]string
+}
+
+// AutoTune adjusts the thread count while mining, one thread at a time, to the
+// count mining fastest. Each count is measured for Trial seconds.
+type AutoTune struct {
+	Enabled bool
+	Trial   int
+}
+
+// Telemetry is the opt-in anonymous hardware/hashrate reporting. Nothing is
+// sent unless Enabled is set and a URL is given. Interval is in seconds.
+type Telemetry struct {
+	Enabled  bool
+	URL      string
+	Interval int
+}
@@ -0,0 +1,78 @@
+package util
+
+import "time"
+
+// Cap on the backoff between connection attempts, so a node or proxy coming
+// back is picked up soon.
+const c_Max_Connect_Delay = time.Minute
+
+// Cap on the backoff between submission retries. A solution is only worth
+// anything for the next few blocks, so it is retried within seconds.
+const c_Max_Submit_Delay = 5 * time.Second
+
+// Age past which a spooled submission is stale and dropped instead of resent.
+const c_Max_Spool_Age = time.Minute
+
+// ConnectivityProfile tunes the miner's networking for the link it runs on.
+type ConnectivityProfile struct {
+	// TCP keepalive period on the proxy connection, 0 keeps the OS default
+	KeepAlive time.Duration
+	// Period of the work requests pinging the proxy, whose session is
+	// dropped when one goes unanswered; 0 disables
+	PingInterval time.Duration
+	// Initial wait between proxy and node dial attempts
+	ReconnectDelay time.Duration
+	// Cap on the exponential backoff of work retries; submission retries
+	// are capped at a few seconds whatever the profile
+	MaxRetryDelay time.Duration
+	// Redial and log back in when the proxy connection drops
+	Reconnect bool
+	// Spool failed submissions to disk and resend them once reconnected
+	Spool bool
+	// Gzip telemetry payloads
+	CompressTelemetry bool
+}
+
+// ConnectivityProfiles are the selectable profiles. "mobile" suits flaky
+// cellular or satellite links.
+var ConnectivityProfiles = map[string]ConnectivityProfile{
+	"default": {
+		PingInterval:   30 * time.Second,
+		ReconnectDelay: time.Second,
+		MaxRetryDelay:  4 * time.Hour,
+		Reconnect:      true,
+	},
+	"mobile": {
+		KeepAlive:         15 * time.Second,
+		PingInterval:      20 * time.Second,
+		ReconnectDelay:    2 * time.Second,
+		MaxRetryDelay:     30 * time.Second,
+		Reconnect:         true,
+		Spool:             true,
+		CompressTelemetry: true,
+	},
+}
+
+// ConnectBackoff spaces out proxy and node dial attempts.
+func (p ConnectivityProfile) ConnectBackoff() *Backoff {
+	max := c_Max_Connect_Delay
+	if p.MaxRetryDelay > 0 && p.MaxRetryDelay < max {
+		max = p.MaxRetryDelay
+	}
+	return NewBackoff(p.ReconnectDelay, max)
+}
+
+// RetryBackoff spaces out work fetch and subscription retries.
+func (p ConnectivityProfile) RetryBackoff() *Backoff {
+	return NewBackoff(time.Second, p.MaxRetryDelay)
+}
+
+// SubmitBackoff spaces out submission retries, capped well below the work
+// retry cap.
+func (p ConnectivityProfile) SubmitBackoff() *Backoff {
+	max := c_Max_Submit_Delay
+	if p.MaxRetryDelay > 0 && p.MaxRetryDelay < max {
+		max = p.MaxRetryDelay
+	}
+	return NewBackoff(500*time.Millisecond, max)
+}
@@ -0,0 +1,70 @@
+package util
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Controller is implemented by the miner to accept remote commands.
+type Controller interface {
+	Pause()
+	Resume()
+	SetThreads(threads int)
+	SetLogLevel(level string) error
+	Snapshot() error
+	Reload() error
+}
+
+// NewControlHandler returns a handler exposing POST /pause, /resume,
+// /intensity?threads=N, /loglevel?level=L, /snapshot and /reload. Every request must carry "Authorization: Bearer <token>".
+func NewControlHandler(token string, c Controller) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		c.Pause()
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		c.Resume()
+	})
+	mux.HandleFunc("/intensity", func(w http.ResponseWriter, r *http.Request) {
+		threads, err := strconv.Atoi(r.URL.Query().Get("threads"))
+		if err != nil || threads < 1 {
+			http.Error(w, "threads must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		c.SetThreads(threads)
+	})
+	mux.HandleFunc("/loglevel", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.SetLogLevel(r.URL.Query().Get("level")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Snapshot(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+	return authorize(token, mux)
+}
+
+// authorize rejects requests that are not POSTs carrying the bearer token.
+func authorize(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
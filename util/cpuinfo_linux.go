@@ -0,0 +1,27 @@
+//go:build linux
+
+package util
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// CPUModel returns the CPU model name from /proc/cpuinfo.
+func CPUModel() string {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.GOARCH
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if found && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return runtime.GOARCH
+}
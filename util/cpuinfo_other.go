@@ -0,0 +1,10 @@
+//go:build !linux
+
+package util
+
+import "runtime"
+
+// CPUModel falls back to the architecture name outside Linux.
+func CPUModel() string {
+	return runtime.GOARCH
+}
@@ -0,0 +1,162 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TwiN/go-color"
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+const (
+	c_Dashboard_Samples = 60
+	c_Dashboard_Blocks  = 5
+	c_Dashboard_Logs    = 10
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// DashboardBlock is a found block listed on the dashboard.
+type DashboardBlock struct {
+	Time   time.Time
+	Order  int
+	Number []*big.Int
+	Hash   common.Hash
+}
+
+// Dashboard redraws a live view of the miner on an ANSI terminal: hashrate
+// graph, work numbers, connection, found blocks and submission outcomes. It
+// is also the log output, keeping the latest lines at the bottom of the view
+// instead of scrolling. A nil dashboard ignores every call.
+type Dashboard struct {
+	out io.Writer
+
+	mu        sync.Mutex
+	hashrates []float64
+	blocks    []DashboardBlock
+	logs      []string
+	partial   []byte
+}
+
+// NewDashboard draws on out, normally the terminal.
+func NewDashboard(out io.Writer) *Dashboard {
+	return &Dashboard{out: out}
+}
+
+// Write takes log output, keeping the latest lines for the view.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.partial = append(d.partial, p...)
+	for {
+		i := bytes.IndexByte(d.partial, '\n')
+		if i < 0 {
+			break
+		}
+		d.logs = append(d.logs, string(d.partial[:i]))
+		d.partial = d.partial[i+1:]
+	}
+	if len(d.logs) > c_Dashboard_Logs {
+		d.logs = d.logs[len(d.logs)-c_Dashboard_Logs:]
+	}
+	return len(p), nil
+}
+
+// AddBlock lists a found block.
+func (d *Dashboard) AddBlock(block DashboardBlock) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.blocks = append(d.blocks, block)
+	if len(d.blocks) > c_Dashboard_Blocks {
+		d.blocks = d.blocks[1:]
+	}
+}
+
+// Render samples the hashrate and redraws the view.
+func (d *Dashboard) Render(status StatusReport, version string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hashrates = append(d.hashrates, status.Hashrate)
+	if len(d.hashrates) > c_Dashboard_Samples {
+		d.hashrates = d.hashrates[1:]
+	}
+
+	var b strings.Builder
+	// Home the cursor and clear the screen.
+	b.WriteString("\x1b[H\x1b[2J")
+	fmt.Fprintf(&b, "Quai CPU miner %s   mode %s   location %v   uptime %s\n\n", version, status.Mode, status.Location, (time.Duration(status.Uptime) * time.Second).String())
+	state := color.Ize(color.Green, "connected")
+	if status.Paused {
+		state = color.Ize(color.Yellow, "paused")
+	} else if !status.Connected {
+		state = color.Ize(color.Red, "disconnected")
+	}
+	fmt.Fprintf(&b, "Status       %s, %d threads\n", state, status.Threads)
+	hr, units := HashrateUnits(status.Hashrate)
+	fmt.Fprintf(&b, "Hashrate     %.2f %s   %s\n", hr, units, status.Hashrates)
+	fmt.Fprintf(&b, "             %s\n", sparkline(d.hashrates))
+	fmt.Fprintf(&b, "Work         prime %d   region %d   zone %d\n", status.Number[common.PRIME_CTX], status.Number[common.REGION_CTX], status.Number[common.ZONE_CTX])
+	fmt.Fprintf(&b, "Blocks found prime %d   region %d   zone %d\n", status.Blocks["prime"], status.Blocks["region"], status.Blocks["zone"])
+	fmt.Fprintf(&b, "Submissions  %s\n", status.Submissions)
+	for _, zone := range status.Zones {
+		hr, units := HashrateUnits(zone.Hashrate)
+		fmt.Fprintf(&b, "Zone %v    %.2f %s, %d threads, work %d, blocks %d\n", zone.Location, hr, units, zone.Threads, zone.Number[common.ZONE_CTX], zone.Blocks["zone"])
+	}
+
+	b.WriteString("\nRecent blocks\n")
+	if len(d.blocks) == 0 {
+		b.WriteString("  none yet\n")
+	}
+	for i := len(d.blocks) - 1; i >= 0; i-- {
+		block := d.blocks[i]
+		fmt.Fprintf(&b, "  %s %-6s %v %s\n", block.Time.Format("15:04:05"), ContextName(block.Order), block.Number, block.Hash.Hex())
+	}
+
+	b.WriteString("\nLog\n")
+	for _, line := range d.logs {
+		fmt.Fprintf(&b, "  %s\n", line)
+	}
+	io.WriteString(d.out, b.String())
+}
+
+// sparkline graphs samples scaled to their maximum.
+func sparkline(samples []float64) string {
+	max := 0.0
+	for _, s := range samples {
+		if s > max {
+			max = s
+		}
+	}
+	graph := make([]rune, len(samples))
+	for i, s := range samples {
+		level := 0
+		if max > 0 {
+			level = int(s / max * float64(len(sparks)-1))
+		}
+		graph[i] = sparks[level]
+	}
+	return string(graph)
+}
+
+// HashrateUnits scales a hashrate in h/s to SI units.
+func HashrateUnits(hashrate float64) (float64, string) {
+	units := []string{"h/s", "Kh/s", "Mh/s", "Gh/s", "Th/s"}
+	reduced := hashrate
+	i := 0
+	for reduced >= 1000 && i < len(units)-1 {
+		reduced /= 1000
+		i++
+	}
+	return reduced, units[i]
+}
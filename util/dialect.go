@@ -0,0 +1,126 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/INFURA/go-ethlibs/jsonrpc"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	log "github.com/sirupsen/logrus"
+)
+
+// Proxy dialects. Both carry Quai headers, they differ in method naming:
+// ethproxy is the eth_* naming of the ethproxy/qtminer stratum variant some
+// early Quai pools expose.
+const (
+	DialectAuto     = "auto"
+	DialectQuai     = "quai"
+	DialectEthproxy = "ethproxy"
+)
+
+const (
+	c_Dialect_Probe_Timeout = 5 * time.Second
+	// JSON-RPC error code for an unknown method.
+	c_Method_Not_Found = -32601
+)
+
+// dialectMethods renames the miner's quai_* requests for each dialect.
+var dialectMethods = map[string]map[string]string{
+	DialectQuai: {},
+	DialectEthproxy: {
+		"quai_submitLogin":        "eth_submitLogin",
+		"quai_getPendingHeader":   "eth_getWork",
+		"quai_receiveMinedHeader": "eth_submitWork",
+		"quai_submitHashrate":     "eth_submitHashrate",
+	},
+}
+
+// SetDialect selects the method naming used on the session.
+func (ms *MinerSession) SetDialect(dialect string) error {
+	if _, ok := dialectMethods[dialect]; !ok {
+		return fmt.Errorf("unknown proxy dialect %q", dialect)
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	ms.dialect = dialect
+	return nil
+}
+
+// Dialect returns the method naming used on the session.
+func (ms *MinerSession) Dialect() string {
+	ms.Lock()
+	defer ms.Unlock()
+	return ms.dialect
+}
+
+// DetectDialect asks the proxy for work in each dialect in turn and keeps the
+// first one answered with a header. It must run before ListenTCP. When no dialect
+// gets an answer the session stays on the quai dialect and it reports false.
+// Callers should remember a detected dialect rather than probe again on
+// every reconnect.
+func (ms *MinerSession) DetectDialect() (string, bool) {
+	for _, dialect := range []string{DialectQuai, DialectEthproxy} {
+		ms.SetDialect(dialect)
+		ok, err := ms.probeDialect()
+		if ok {
+			log.Printf("Proxy speaks the %s dialect", dialect)
+			return dialect, true
+		}
+		log.Warnf("Proxy doesn't answer the %s dialect: %v", dialect, err)
+	}
+	ms.SetDialect(DialectQuai)
+	log.Warnf("Unable to detect proxy dialect, using %s", DialectQuai)
+	return DialectQuai, false
+}
+
+// probeDialect requests a pending header and reports whether the proxy knows
+// the method. Replies to other requests, such as a late answer to an earlier
+// probe, are skipped.
+func (ms *MinerSession) probeDialect() (bool, error) {
+	id := ms.nextID()
+	msg, err := jsonrpc.MakeRequest(int(id), "quai_getPendingHeader", nil)
+	if err != nil {
+		return false, err
+	}
+	if err := ms.SendTCPRequest(*msg); err != nil {
+		return false, err
+	}
+	ms.conn.SetReadDeadline(time.Now().Add(c_Dialect_Probe_Timeout))
+	defer ms.conn.SetReadDeadline(time.Time{})
+	for {
+		data, err := readFrame(ms.reader, c_Max_Msg_Size)
+		if err != nil {
+			return false, err
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var resp *rpc.JsonRPCResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return false, err
+		}
+		if string(bytes.TrimSpace(resp.ID)) != strconv.FormatUint(id, 10) {
+			continue
+		}
+		if resp.Error != nil {
+			if resp.Error.Code == c_Method_Not_Found {
+				return false, fmt.Errorf("error reply: %s", resp.Error.Message)
+			}
+			// The method exists but wants something first, e.g. a login.
+			return true, nil
+		}
+		// Both dialects carry a Quai header object. The classic ethproxy
+		// array of work fields has no header to seal, so it doesn't count.
+		if resp.Result != nil && bytes.HasPrefix(*resp.Result, []byte("[")) {
+			return false, fmt.Errorf("reply is array getwork, which carries no Quai header")
+		}
+		if resp.Result == nil || !bytes.HasPrefix(*resp.Result, []byte("{")) {
+			return false, fmt.Errorf("reply is not a header")
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,195 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	c_MDNS_Addr    = "224.0.0.251:5353"
+	c_MDNS_Timeout = 2 * time.Second
+)
+
+// Discovery finds the slice node endpoints instead of listing them in the
+// config. Domain looks up DNS SRV records, MDNS queries the LAN. The records
+// are, for the mining location r-z:
+//
+//	_quai-prime._tcp.<domain>
+//	_quai-region-<r>._tcp.<domain>
+//	_quai-zone-<r>-<z>._tcp.<domain>
+//
+// with "local" as the domain for mDNS. Scheme defaults to ws.
+type Discovery struct {
+	Domain string
+	MDNS   bool
+	Scheme string
+}
+
+// Enabled reports whether any discovery method is configured.
+func (d Discovery) Enabled() bool {
+	return d.Domain != "" || d.MDNS
+}
+
+// DiscoverNodes fills in the prime, region and zone URLs for the config's
+// location. Nodes that can't be discovered keep their configured URL.
+func DiscoverNodes(config *Config) {
+	d := config.Discovery
+	scheme := d.Scheme
+	if scheme == "" {
+		scheme = "ws"
+	}
+	region, zone := config.Location.Region(), config.Location.Zone()
+	for len(config.RegionURLs) <= region {
+		config.RegionURLs = append(config.RegionURLs, "")
+	}
+	for len(config.ZoneURLs) <= region {
+		config.ZoneURLs = append(config.ZoneURLs, nil)
+	}
+	for len(config.ZoneURLs[region]) <= zone {
+		config.ZoneURLs[region] = append(config.ZoneURLs[region], "")
+	}
+	targets := []struct {
+		service string
+		url     *string
+	}{
+		{"quai-prime", &config.PrimeURL},
+		{fmt.Sprintf("quai-region-%d", region), &config.RegionURLs[region]},
+		{fmt.Sprintf("quai-zone-%d-%d", region, zone), &config.ZoneURLs[region][zone]},
+	}
+	for i, target := range targets {
+		host, port, err := discoverService(d, target.service)
+		if err != nil {
+			log.Warnf("Unable to discover %s node, using %q: %v", ContextName(i), *target.url, err)
+			continue
+		}
+		*target.url = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(int(port))))
+		log.Printf("Discovered %s node at %s", ContextName(i), *target.url)
+	}
+}
+
+// ContextName names a context in logs, payloads and config.
+func ContextName(ctx int) string {
+	switch ctx {
+	case common.PRIME_CTX:
+		return "prime"
+	case common.REGION_CTX:
+		return "region"
+	default:
+		return "zone"
+	}
+}
+
+// discoverService tries DNS SRV first, then mDNS.
+func discoverService(d Discovery, service string) (string, uint16, error) {
+	var errs []string
+	if d.Domain != "" {
+		_, records, err := net.LookupSRV(service, "tcp", d.Domain)
+		if err == nil && len(records) > 0 {
+			// Records come sorted by priority and shuffled by weight.
+			return strings.TrimSuffix(records[0].Target, "."), records[0].Port, nil
+		}
+		errs = append(errs, fmt.Sprintf("srv: %v", err))
+	}
+	if d.MDNS {
+		host, port, err := lookupMDNS(fmt.Sprintf("_%s._tcp.local.", service))
+		if err == nil {
+			return host, port, nil
+		}
+		errs = append(errs, fmt.Sprintf("mdns: %v", err))
+	}
+	return "", 0, errors.New(strings.Join(errs, "; "))
+}
+
+// lookupMDNS resolves an SRV record on the LAN with a one-shot mDNS query,
+// returning the target's address when the responder includes it.
+func lookupMDNS(name string) (string, uint16, error) {
+	answers, err := queryMDNS(name, dnsmessage.TypeSRV)
+	if err != nil {
+		return "", 0, err
+	}
+	var srv *dnsmessage.SRVResource
+	for _, answer := range answers {
+		if body, ok := answer.Body.(*dnsmessage.SRVResource); ok && strings.EqualFold(answer.Header.Name.String(), name) {
+			srv = body
+			break
+		}
+	}
+	if srv == nil {
+		return "", 0, errors.New("no SRV record")
+	}
+	target := srv.Target.String()
+	// Prefer an address from the same response, else ask for it.
+	for _, answer := range answers {
+		if body, ok := answer.Body.(*dnsmessage.AResource); ok && strings.EqualFold(answer.Header.Name.String(), target) {
+			return net.IP(body.A[:]).String(), srv.Port, nil
+		}
+	}
+	answers, err = queryMDNS(target, dnsmessage.TypeA)
+	if err == nil {
+		for _, answer := range answers {
+			if body, ok := answer.Body.(*dnsmessage.AResource); ok {
+				return net.IP(body.A[:]).String(), srv.Port, nil
+			}
+		}
+	}
+	return strings.TrimSuffix(target, "."), srv.Port, nil
+}
+
+// queryMDNS sends a legacy unicast mDNS query (RFC 6762 section 6.7), so
+// responders answer straight to our socket, and collects the answer and
+// additional records received before the timeout.
+func queryMDNS(name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	group, err := net.ResolveUDPAddr("udp4", c_MDNS_Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(packet, group); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(c_MDNS_Timeout))
+	var records []dnsmessage.Resource
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || !msg.Header.Response {
+			continue
+		}
+		records = append(records, msg.Answers...)
+		records = append(records, msg.Additionals...)
+		if len(msg.Answers) > 0 {
+			break
+		}
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no mDNS response")
+	}
+	return records, nil
+}
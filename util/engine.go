@@ -0,0 +1,135 @@
+package util
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/consensus/progpow"
+	"github.com/dominant-strategies/go-quai/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// PowEngine is the part of a consensus engine the miner seals with.
+type PowEngine interface {
+	Seal(header *types.Header, results chan<- *types.Header, stop <-chan struct{}) error
+	CalcOrder(header *types.Header) (*big.Int, int, error)
+	Hashrate() float64
+	Threads() int
+	SetThreads(threads int)
+}
+
+// EngineConstructor builds a fresh PoW engine.
+type EngineConstructor func() PowEngine
+
+var engineRegistry = map[string]EngineConstructor{
+	"progpow": func() PowEngine {
+		return progpow.New(progpow.Config{NotifyFull: true}, nil, false)
+	},
+}
+
+// RegisterEngine makes a PoW algorithm available to engine schedules.
+func RegisterEngine(algorithm string, constructor EngineConstructor) {
+	engineRegistry[algorithm] = constructor
+}
+
+// Fork activates Algorithm from zone block Block onwards.
+type Fork struct {
+	Algorithm string
+	Block     uint64
+}
+
+// EngineSchedule carries one engine per algorithm and dispatches every call to
+// the engine active at the header's zone block number, so a fork to a new PoW
+// algorithm needs no binary swap. It implements PowEngine itself.
+type EngineSchedule struct {
+	forks   []Fork
+	engines map[string]PowEngine
+
+	lock   sync.Mutex
+	active string
+}
+
+// NewEngineSchedule builds the engines for the given forks. An empty schedule
+// mines progpow from genesis.
+func NewEngineSchedule(forks []Fork) (*EngineSchedule, error) {
+	if len(forks) == 0 {
+		forks = []Fork{{Algorithm: "progpow", Block: 0}}
+	}
+	forks = append([]Fork(nil), forks...)
+	sort.Slice(forks, func(i, j int) bool { return forks[i].Block < forks[j].Block })
+	if forks[0].Block != 0 {
+		return nil, fmt.Errorf("first fork must start at block 0, got %d", forks[0].Block)
+	}
+	s := &EngineSchedule{forks: forks, engines: make(map[string]PowEngine)}
+	for _, fork := range forks {
+		if _, ok := s.engines[fork.Algorithm]; ok {
+			continue
+		}
+		constructor, ok := engineRegistry[fork.Algorithm]
+		if !ok {
+			return nil, fmt.Errorf("unknown PoW algorithm %q", fork.Algorithm)
+		}
+		s.engines[fork.Algorithm] = constructor()
+	}
+	return s, nil
+}
+
+// EngineAt returns the algorithm and engine active at the zone block number.
+func (s *EngineSchedule) EngineAt(number uint64) (string, PowEngine) {
+	algorithm := s.forks[0].Algorithm
+	for _, fork := range s.forks {
+		if number < fork.Block {
+			break
+		}
+		algorithm = fork.Algorithm
+	}
+	return algorithm, s.engines[algorithm]
+}
+
+func (s *EngineSchedule) engineFor(header *types.Header) PowEngine {
+	algorithm, engine := s.EngineAt(header.NumberU64(common.ZONE_CTX))
+	s.lock.Lock()
+	if algorithm != s.active {
+		if s.active != "" {
+			log.Println("Switching PoW algorithm from", s.active, "to", algorithm, "at block", header.NumberU64(common.ZONE_CTX))
+		}
+		s.active = algorithm
+	}
+	s.lock.Unlock()
+	return engine
+}
+
+// Seal seals the header with the engine active at its block number.
+func (s *EngineSchedule) Seal(header *types.Header, results chan<- *types.Header, stop <-chan struct{}) error {
+	return s.engineFor(header).Seal(header, results, stop)
+}
+
+// CalcOrder verifies the header with the engine active at its block number.
+func (s *EngineSchedule) CalcOrder(header *types.Header) (*big.Int, int, error) {
+	_, engine := s.EngineAt(header.NumberU64(common.ZONE_CTX))
+	return engine.CalcOrder(header)
+}
+
+// Hashrate sums the hashrate of all engines. Idle engines decay to zero.
+func (s *EngineSchedule) Hashrate() float64 {
+	total := 0.0
+	for _, engine := range s.engines {
+		total += engine.Hashrate()
+	}
+	return total
+}
+
+// Threads returns the thread count shared by all engines.
+func (s *EngineSchedule) Threads() int {
+	return s.engines[s.forks[0].Algorithm].Threads()
+}
+
+// SetThreads sets the thread count on every engine.
+func (s *EngineSchedule) SetThreads(threads int) {
+	for _, engine := range s.engines {
+		engine.SetThreads(threads)
+	}
+}
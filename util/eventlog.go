@@ -0,0 +1,78 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event names written to the event log.
+const (
+	EventWork     = "work"
+	EventSeal     = "seal"
+	EventSolution = "solution"
+	EventSubmit   = "submit"
+	EventAck      = "ack"
+)
+
+// EventFields are the event specific fields of an event log record.
+type EventFields map[string]interface{}
+
+// eventLog is the append-only JSONL file events are recorded to. It stays
+// closed unless OpenEventLog is called, making LogEvent a no-op.
+var eventLog struct {
+	sync.Mutex
+	file  *os.File
+	enc   *json.Encoder
+	start time.Time
+}
+
+// OpenEventLog starts appending events to the file at path.
+func OpenEventLog(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	eventLog.Lock()
+	defer eventLog.Unlock()
+	eventLog.file = file
+	eventLog.enc = json.NewEncoder(file)
+	eventLog.start = time.Now()
+	return nil
+}
+
+// CloseEventLog flushes and closes the event log.
+func CloseEventLog() {
+	eventLog.Lock()
+	defer eventLog.Unlock()
+	if eventLog.file == nil {
+		return
+	}
+	eventLog.file.Sync()
+	eventLog.file.Close()
+	eventLog.file = nil
+	eventLog.enc = nil
+}
+
+// LogEvent appends one record. Besides the wall clock time every record has
+// "mono", the nanoseconds since the log was opened on the monotonic clock, so
+// intervals between events stay exact across wall clock adjustments. "start"
+// tells the records of different runs apart.
+func LogEvent(event string, fields EventFields) {
+	eventLog.Lock()
+	defer eventLog.Unlock()
+	if eventLog.enc == nil {
+		return
+	}
+	now := time.Now()
+	record := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		record[k] = v
+	}
+	record["event"] = event
+	record["time"] = now.UTC().Format(time.RFC3339Nano)
+	record["mono"] = now.Sub(eventLog.start).Nanoseconds()
+	record["start"] = eventLog.start.UnixNano()
+	eventLog.enc.Encode(record)
+}
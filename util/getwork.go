@@ -0,0 +1,84 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/INFURA/go-ethlibs/jsonrpc"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+// GetworkClient polls a getwork-style HTTP endpoint for work. The endpoint
+// must answer eth_getWork with a full header object, the way the go-quai
+// remote sealer notifies full headers, since a bare sealhash can't be sealed.
+type GetworkClient struct {
+	url      string
+	client   *http.Client
+	latestId uint64
+}
+
+func NewGetworkClient(url string) *GetworkClient {
+	return &GetworkClient{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// GetWork fetches the current work from the endpoint.
+func (c *GetworkClient) GetWork() (*types.Header, error) {
+	result, err := c.call("eth_getWork")
+	if err != nil {
+		return nil, err
+	}
+	if len(result) > 0 && result[0] == '[' {
+		return nil, errors.New("endpoint returned a bare work package instead of a full header")
+	}
+	var header *types.Header
+	if err := json.Unmarshal(result, &header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// SubmitWork submits the sealed header's nonce and mix digest for its sealhash.
+func (c *GetworkClient) SubmitWork(header *types.Header) (bool, error) {
+	result, err := c.call("eth_submitWork", header.Nonce(), header.SealHash(), header.MixHash())
+	if err != nil {
+		return false, err
+	}
+	var accepted bool
+	err = json.Unmarshal(result, &accepted)
+	return accepted, err
+}
+
+func (c *GetworkClient) call(method string, params ...interface{}) (json.RawMessage, error) {
+	msg, err := jsonrpc.MakeRequest(int(atomic.AddUint64(&c.latestId, 1)), method, params...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var rpcResp *rpc.JsonRPCResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return nil, err
+	}
+	if rpcResp.Error != nil {
+		return nil, errors.New(rpcResp.Error.Message)
+	}
+	if rpcResp.Result == nil {
+		return nil, fmt.Errorf("empty result for %s", method)
+	}
+	return *rpcResp.Result, nil
+}
@@ -0,0 +1,161 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/crypto"
+)
+
+const (
+	// Work packages kept for late submissions.
+	c_Bridge_Work_History = 8
+	// Blocks per progpow epoch, as in the go-quai engine.
+	c_Epoch_Length = 30000
+)
+
+// GetworkBridge serves the node's pending header as Ethereum-style getwork
+// to external miners that don't speak quai_*: eth_getWork answers
+// [sealhash, seed hash, target, zone number] and eth_submitWork takes
+// [nonce, sealhash, mix digest]. Solutions are verified before being handed
+// on for submission.
+type GetworkBridge struct {
+	engine PowEngine
+	submit func(*types.Header)
+
+	mu     sync.Mutex
+	recent []*types.Header // oldest first
+	paused bool
+}
+
+// NewGetworkBridge verifies solutions with engine and passes the solved
+// headers to submit.
+func NewGetworkBridge(engine PowEngine, submit func(*types.Header)) *GetworkBridge {
+	return &GetworkBridge{engine: engine, submit: submit}
+}
+
+// Update makes header the work served to miners.
+func (b *GetworkBridge) Update(header *types.Header) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.recent = append(b.recent, header)
+	if len(b.recent) > c_Bridge_Work_History {
+		b.recent = b.recent[1:]
+	}
+}
+
+// SetPaused stops or resumes serving work. Solutions for work already handed
+// out are still accepted.
+func (b *GetworkBridge) SetPaused(paused bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused = paused
+}
+
+func (b *GetworkBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := rpc.JsonRPCResponse{ID: req.ID}
+	var result interface{}
+	switch req.Method {
+	case "eth_getWork":
+		work, err := b.work()
+		if err != nil {
+			resp.Error = &rpc.JsonError{Code: -32000, Message: err.Error()}
+		} else {
+			result = work
+		}
+	case "eth_submitWork":
+		result = b.submitWork(req.Params)
+	case "eth_submitHashrate":
+		result = true
+	default:
+		resp.Error = &rpc.JsonError{Code: c_Method_Not_Found, Message: "method not found"}
+	}
+	if result != nil {
+		raw, _ := json.Marshal(result)
+		msg := json.RawMessage(raw)
+		resp.Result = &msg
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// work is the getwork package of the latest header.
+func (b *GetworkBridge) work() ([4]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.paused {
+		return [4]string{}, fmt.Errorf("paused")
+	}
+	if len(b.recent) == 0 {
+		return [4]string{}, fmt.Errorf("no work yet")
+	}
+	header := b.recent[len(b.recent)-1]
+	number := header.NumberU64(common.ZONE_CTX)
+	target := new(big.Int).Div(big2e256, header.Difficulty())
+	return [4]string{
+		header.SealHash().Hex(),
+		seedHash(number).Hex(),
+		common.BytesToHash(target.Bytes()).Hex(),
+		hexutil.EncodeUint64(number),
+	}, nil
+}
+
+// submitWork verifies a solution for recent work and submits it, reporting
+// whether it was valid.
+func (b *GetworkBridge) submitWork(params []json.RawMessage) bool {
+	if len(params) != 3 {
+		return false
+	}
+	var (
+		nonce    types.BlockNonce
+		sealHash common.Hash
+		mixHash  common.Hash
+	)
+	if json.Unmarshal(params[0], &nonce) != nil || json.Unmarshal(params[1], &sealHash) != nil || json.Unmarshal(params[2], &mixHash) != nil {
+		return false
+	}
+	var header *types.Header
+	b.mu.Lock()
+	for _, work := range b.recent {
+		if work.SealHash() == sealHash {
+			header = types.CopyHeader(work)
+		}
+	}
+	b.mu.Unlock()
+	if header == nil {
+		return false
+	}
+	header.SetNonce(nonce)
+	header.SetMixHash(&mixHash)
+	if VerifySolution(b.engine, header).Problem() != nil {
+		return false
+	}
+	b.submit(header)
+	return true
+}
+
+// seedHash is the progpow seed of an epoch: keccak256 applied once per epoch
+// to 32 zero bytes.
+func seedHash(number uint64) common.Hash {
+	var seed common.Hash
+	for i := uint64(0); i < number/c_Epoch_Length; i++ {
+		seed = crypto.Keccak256Hash(seed.Bytes())
+	}
+	return seed
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SetCPUGovernor switches the cpufreq governor of every core and returns a
+// function that restores the previous governors.
+func SetCPUGovernor(governor string) (func(), error) {
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	if len(paths) == 0 {
+		return nil, errors.New("no cpufreq governors found")
+	}
+	previous := make(map[string]string, len(paths))
+	restore := func() {
+		for path, old := range previous {
+			if err := os.WriteFile(path, []byte(old), 0644); err != nil {
+				log.Warnf("Unable to restore CPU governor %s: %v", path, err)
+			}
+		}
+	}
+	for _, path := range paths {
+		old, err := os.ReadFile(path)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(governor), 0644); err != nil {
+			restore()
+			return nil, err
+		}
+		previous[path] = strings.TrimSpace(string(old))
+	}
+	return restore, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package util
+
+import "errors"
+
+// SetCPUGovernor is only supported on Linux.
+func SetCPUGovernor(governor string) (func(), error) {
+	return nil, errors.New("setting the CPU governor is not supported on this platform")
+}
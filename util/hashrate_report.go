@@ -0,0 +1,39 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+	"github.com/dominant-strategies/go-quai/crypto"
+)
+
+const c_Hashrate_Report_Timeout = 10 * time.Second
+
+// ErrMethodNotFound is returned when the proxy doesn't know a method.
+var ErrMethodNotFound = errors.New("method not supported by the proxy")
+
+// RigID is the identifier hashrate reports are filed under: the hash of name,
+// or of the hostname when name is empty, so it stays the same across restarts.
+func RigID(name string) common.Hash {
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return crypto.Keccak256Hash([]byte(name))
+}
+
+// SubmitHashrate reports the hashrate for the rig, as eth_submitHashrate
+// does, and waits for the proxy to acknowledge it.
+func (ms *MinerSession) SubmitHashrate(hashrate float64, rig common.Hash) error {
+	call, err := ms.Call("quai_submitHashrate", hexutil.Uint64(hashrate), rig)
+	if err != nil {
+		return err
+	}
+	resp, err := call.Wait(c_Hashrate_Report_Timeout)
+	if resp != nil && resp.Error != nil && resp.Error.Code == c_Method_Not_Found {
+		return ErrMethodNotFound
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Time constants of the hashrate moving averages.
+var hashrateWindows = [3]time.Duration{time.Minute, 15 * time.Minute, time.Hour}
+
+// HashrateSummary is the hashrate trend of a session in h/s: exponential
+// moving averages over 1 minute, 15 minutes and 1 hour, the peak and the
+// session average of the samples.
+type HashrateSummary struct {
+	EMA1m   float64 `json:"ema1m"`
+	EMA15m  float64 `json:"ema15m"`
+	EMA1h   float64 `json:"ema1h"`
+	Peak    float64 `json:"peak"`
+	Average float64 `json:"average"`
+}
+
+func (s HashrateSummary) String() string {
+	format := func(hashrate float64) string {
+		hr, units := HashrateUnits(hashrate)
+		return fmt.Sprintf("%.2f %s", hr, units)
+	}
+	return fmt.Sprintf("1m %s, 15m %s, 1h %s, peak %s, average %s", format(s.EMA1m), format(s.EMA15m), format(s.EMA1h), format(s.Peak), format(s.Average))
+}
+
+// HashrateStats tracks the hashrate trend from periodic samples. Samples are
+// weighted by the time since the previous one, so the averages hold up when
+// the sampling interval varies. The zero value is ready to use.
+type HashrateStats struct {
+	mu      sync.Mutex
+	ema     [3]float64
+	last    time.Time
+	peak    float64
+	sum     float64
+	samples int
+}
+
+// Add takes a hashrate sample taken at t.
+func (s *HashrateStats) Add(hashrate float64, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples == 0 {
+		for i := range s.ema {
+			s.ema[i] = hashrate
+		}
+	} else if dt := t.Sub(s.last); dt > 0 {
+		for i, window := range hashrateWindows {
+			alpha := 1 - math.Exp(-float64(dt)/float64(window))
+			s.ema[i] += alpha * (hashrate - s.ema[i])
+		}
+	}
+	s.last = t
+	if hashrate > s.peak {
+		s.peak = hashrate
+	}
+	s.sum += hashrate
+	s.samples++
+}
+
+// Summary reports the trend so far.
+func (s *HashrateStats) Summary() HashrateSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary := HashrateSummary{EMA1m: s.ema[0], EMA15m: s.ema[1], EMA1h: s.ema[2], Peak: s.peak}
+	if s.samples > 0 {
+		summary.Average = s.sum / float64(s.samples)
+	}
+	return summary
+}
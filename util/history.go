@@ -0,0 +1,186 @@
+package util
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS blocks (
+	hash      TEXT PRIMARY KEY,
+	seal_hash TEXT NOT NULL,
+	found     INTEGER NOT NULL, -- unix milliseconds
+	sealing   INTEGER NOT NULL, -- milliseconds spent sealing the work
+	context   TEXT NOT NULL,
+	number    TEXT NOT NULL,
+	location  TEXT NOT NULL,
+	nonce     INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS submissions (
+	hash     TEXT NOT NULL REFERENCES blocks(hash),
+	target   TEXT NOT NULL,
+	time     INTEGER NOT NULL, -- unix milliseconds
+	accepted INTEGER NOT NULL,
+	error    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS submissions_hash ON submissions(hash);
+`
+
+// History is a SQLite record of every sealed header and the outcome of its
+// submissions. Its methods are no-ops on a nil History.
+type History struct {
+	db *sql.DB
+}
+
+// HistoryBlock is a found block and its submissions.
+type HistoryBlock struct {
+	Hash        common.Hash
+	SealHash    common.Hash
+	Found       time.Time
+	Sealing     time.Duration
+	Context     string
+	Number      []uint64
+	Location    []int
+	Nonce       uint64
+	Submissions []HistorySubmission
+}
+
+// HistorySubmission is the outcome of submitting a block to one target.
+type HistorySubmission struct {
+	Target   string
+	Time     time.Time
+	Accepted bool
+	Error    string
+}
+
+// OpenHistory opens the database at path, creating it if needed.
+func OpenHistory(path string) (*History, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes writers, which SQLite would otherwise
+	// reject as busy.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &History{db: db}, nil
+}
+
+// Close closes the database.
+func (h *History) Close() error {
+	if h == nil {
+		return nil
+	}
+	return h.db.Close()
+}
+
+// RecordBlock records a sealed header found at the order after sealing for
+// the given time.
+func (h *History) RecordBlock(header *types.Header, order int, sealing time.Duration) error {
+	if h == nil {
+		return nil
+	}
+	number := make([]uint64, common.HierarchyDepth)
+	for ctx := range number {
+		number[ctx] = header.NumberU64(ctx)
+	}
+	numberJSON, _ := json.Marshal(number)
+	locationJSON, _ := json.Marshal(LocationIndices(header.Location()))
+	_, err := h.db.Exec(`INSERT OR IGNORE INTO blocks VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		header.Hash().Hex(), header.SealHash().Hex(), time.Now().UnixMilli(), sealing.Milliseconds(),
+		ContextName(order), string(numberJSON), string(locationJSON), int64(header.NonceU64()))
+	return err
+}
+
+// RecordSubmission records the outcome of submitting the header to target.
+func (h *History) RecordSubmission(header *types.Header, target string, accepted bool, submitErr error) error {
+	if h == nil {
+		return nil
+	}
+	message := ""
+	if submitErr != nil {
+		message = submitErr.Error()
+	}
+	_, err := h.db.Exec(`INSERT INTO submissions VALUES (?, ?, ?, ?, ?)`,
+		header.Hash().Hex(), target, time.Now().UnixMilli(), accepted, message)
+	return err
+}
+
+// Blocks returns the latest limit blocks, newest first, optionally only those
+// of one context.
+func (h *History) Blocks(limit int, context string) ([]HistoryBlock, error) {
+	query := `SELECT hash, seal_hash, found, sealing, context, number, location, nonce FROM blocks`
+	args := []interface{}{}
+	if context != "" {
+		query += ` WHERE context = ?`
+		args = append(args, context)
+	}
+	query += ` ORDER BY found DESC LIMIT ?`
+	args = append(args, limit)
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var blocks []HistoryBlock
+	for rows.Next() {
+		var (
+			b                           HistoryBlock
+			hash, sealHash, number, loc string
+			found, sealing              int64
+			nonce                       int64
+		)
+		if err := rows.Scan(&hash, &sealHash, &found, &sealing, &b.Context, &number, &loc, &nonce); err != nil {
+			return nil, err
+		}
+		b.Hash, b.SealHash = common.HexToHash(hash), common.HexToHash(sealHash)
+		b.Found, b.Sealing = time.UnixMilli(found), time.Duration(sealing)*time.Millisecond
+		b.Nonce = uint64(nonce)
+		if err := json.Unmarshal([]byte(number), &b.Number); err != nil {
+			return nil, fmt.Errorf("block %s: %v", hash, err)
+		}
+		if err := json.Unmarshal([]byte(loc), &b.Location); err != nil {
+			return nil, fmt.Errorf("block %s: %v", hash, err)
+		}
+		blocks = append(blocks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range blocks {
+		if blocks[i].Submissions, err = h.submissions(blocks[i].Hash); err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+func (h *History) submissions(hash common.Hash) ([]HistorySubmission, error) {
+	rows, err := h.db.Query(`SELECT target, time, accepted, error FROM submissions WHERE hash = ? ORDER BY time`, hash.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var submissions []HistorySubmission
+	for rows.Next() {
+		var (
+			s  HistorySubmission
+			at int64
+		)
+		if err := rows.Scan(&s.Target, &at, &s.Accepted, &s.Error); err != nil {
+			return nil, err
+		}
+		s.Time = time.UnixMilli(at)
+		submissions = append(submissions, s)
+	}
+	return submissions, rows.Err()
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	c_Hook_Timeout = 30 * time.Second
+)
+
+// Hook is an external action fired on miner events. Exec runs a command with
+// the event payload as JSON on stdin and its top-level fields in MINER_*
+// environment variables, URL receives the payload as a POST.
+type Hook struct {
+	Exec string
+	URL  string
+}
+
+// Enabled reports whether the hook has anything to run.
+func (h Hook) Enabled() bool {
+	return h.Exec != "" || h.URL != ""
+}
+
+// Fire runs the hook's command and/or posts to its endpoint.
+func (h Hook) Fire(payload interface{}) error {
+	if !h.Enabled() {
+		return nil
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c_Hook_Timeout)
+	defer cancel()
+
+	if h.Exec != "" {
+		cmd := exec.CommandContext(ctx, h.Exec)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Env = append(os.Environ(), hookEnv(data)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("hook %s failed: %v: %s", h.Exec, err, out)
+		}
+	}
+	if h.URL != "" {
+		req, err := newJSONRequest(ctx, h.URL, data)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("hook %s returned %s", h.URL, resp.Status)
+		}
+	}
+	return nil
+}
+
+// hookEnv turns the payload's top-level fields into MINER_<FIELD> variables,
+// e.g. MINER_EVENT=on_block_found. Strings are passed bare, anything else as
+// JSON.
+func hookEnv(data []byte) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	env := make([]string, 0, len(fields))
+	for key, raw := range fields {
+		value := string(raw)
+		var str string
+		if json.Unmarshal(raw, &str) == nil {
+			value = str
+		}
+		env = append(env, "MINER_"+strings.ToUpper(key)+"="+value)
+	}
+	return env
+}
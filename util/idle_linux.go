@@ -0,0 +1,24 @@
+//go:build linux
+
+package util
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserIdleTime returns how long the X11 desktop has gone without keyboard or
+// mouse input, read through xprintidle.
+func UserIdleTime() (time.Duration, error) {
+	out, err := exec.Command("xprintidle").Output()
+	if err != nil {
+		return 0, err
+	}
+	ms, err := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
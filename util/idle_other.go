@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package util
+
+import (
+	"errors"
+	"time"
+)
+
+// UserIdleTime is only supported on Linux and Windows.
+func UserIdleTime() (time.Duration, error) {
+	return 0, errors.New("reading the desktop idle time is not supported on this platform")
+}
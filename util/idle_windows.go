@@ -0,0 +1,33 @@
+//go:build windows
+
+package util
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procGetLastInputInfo = windows.NewLazySystemDLL("user32.dll").NewProc("GetLastInputInfo")
+	procGetTickCount     = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetTickCount")
+)
+
+// LASTINPUTINFO
+type lastInputInfo struct {
+	size uint32
+	time uint32
+}
+
+// UserIdleTime returns how long the desktop session has gone without keyboard
+// or mouse input.
+func UserIdleTime() (time.Duration, error) {
+	info := lastInputInfo{size: uint32(unsafe.Sizeof(lastInputInfo{}))}
+	if ok, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info))); ok == 0 {
+		return 0, err
+	}
+	now, _, _ := procGetTickCount.Call()
+	// Both are milliseconds since boot, wrapping every 49.7 days.
+	return time.Duration(uint32(now)-info.time) * time.Millisecond, nil
+}
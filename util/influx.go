@@ -0,0 +1,69 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var influxClient = &http.Client{Timeout: 10 * time.Second}
+
+// influxEscaper escapes measurement names, tag keys and tag values for the
+// line protocol.
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// InfluxLines formats the stats as one line-protocol point of the quai_miner
+// measurement, tagged with tags and stamped at t.
+func InfluxLines(stats MetricsStats, tags map[string]string, t time.Time) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("quai_miner")
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	// The line protocol wants tags sorted by key for the best write performance.
+	sort.Strings(keys)
+	for _, key := range keys {
+		if tags[key] == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, ",%s=%s", influxEscaper.Replace(key), influxEscaper.Replace(tags[key]))
+	}
+	fmt.Fprintf(&buf, " hashrate=%g,threads=%di,connected=%t", stats.Hashrate, stats.Threads, stats.Connected)
+	for ctx, blocks := range stats.Blocks {
+		fmt.Fprintf(&buf, ",blocks_%s=%di", ContextName(ctx), blocks)
+	}
+	fmt.Fprintf(&buf, ",accepted=%di,rejected=%di,stale=%di", stats.Submissions.Accepted, stats.Submissions.Rejected, stats.Submissions.Stale)
+	fmt.Fprintf(&buf, ",invalid=%di,submit_errors=%di,reconnects=%di", stats.Invalid, stats.SubmitErrors, stats.Reconnects)
+	fmt.Fprintf(&buf, ",work_latency=%g %d\n", stats.WorkLatency.Seconds(), t.UnixNano())
+	return buf.Bytes()
+}
+
+// PushInflux writes line-protocol data to an InfluxDB or VictoriaMetrics write
+// endpoint, e.g. http://host:8086/write?db=mining for InfluxDB 1.x and
+// VictoriaMetrics, or http://host:8086/api/v2/write?org=o&bucket=b for 2.x
+// with token. Pushes are signed like webhooks when a payload secret is set.
+func PushInflux(url, token string, data []byte) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	signRequest(req, data)
+	if token != "" {
+		req.Header.Set("Authorization", "Token "+token)
+	}
+	resp, err := influxClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("influx endpoint returned %s", resp.Status)
+	}
+	return nil
+}
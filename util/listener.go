@@ -0,0 +1,91 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Listener is the bind address and client allowlist of one listener.
+// Allow holds CIDRs; when it is empty only loopback clients are accepted, so
+// exposing a listener beyond the host is always an explicit decision.
+type Listener struct {
+	Addr  string
+	Allow []string
+}
+
+// Listeners groups every listener the miner can run.
+type Listeners struct {
+	Control Listener
+	SNMP    Listener // UDP
+	Metrics Listener
+	Status  Listener
+	Pprof   Listener
+	Getwork Listener // getwork bridge for external miners
+}
+
+// Enabled reports whether the listener has a bind address.
+func (l Listener) Enabled() bool {
+	return l.Addr != ""
+}
+
+// Serve serves handler on the listener's address, rejecting clients that
+// aren't in the allowlist except on the open paths. It blocks until the
+// server fails.
+func (l Listener) Serve(name string, handler http.Handler, open ...string) error {
+	allowed, err := parseAllowlist(l.Allow)
+	if err != nil {
+		return fmt.Errorf("invalid %s allowlist: %v", name, err)
+	}
+	log.Debugf("Starting %s listener on %s (allow %v)", name, l.Addr, l.Allow)
+	return http.ListenAndServe(l.Addr, allowlist(allowed, open, handler))
+}
+
+func parseAllowlist(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func allowlist(allowed []netip.Prefix, open []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, path := range open {
+			if r.URL.Path == path {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !isAllowed(allowed, addr.Unmap()) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isAllowed(allowed []netip.Prefix, addr netip.Addr) bool {
+	if len(allowed) == 0 {
+		return addr.IsLoopback()
+	}
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	"github.com/TwiN/go-color"
+	log "github.com/sirupsen/logrus"
+)
+
+// Log output formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// SetupLogging sets the level (debug, info, warn or error) and format of the
+// miner's log. JSON output carries one object per line and no color codes, for
+// log shippers.
+func SetupLogging(level, format string) error {
+	if level == "" {
+		level = "info"
+	}
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lvl)
+	log.SetReportCaller(true)
+	switch format {
+	case "", LogFormatText:
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true, CallerPrettyfier: shortCaller})
+	case LogFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{CallerPrettyfier: shortCaller})
+		color.Toggle(false)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
+
+// shortCaller reports the caller as file:line, like the standard logger's
+// Lshortfile.
+func shortCaller(frame *runtime.Frame) (string, string) {
+	return "", fmt.Sprintf("%s:%d", filepath.Base(frame.File), frame.Line)
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// MetricsStats is the snapshot of miner state served as Prometheus metrics.
+type MetricsStats struct {
+	Hashrate     float64
+	Hashrates    HashrateSummary
+	Blocks       [3]uint64
+	SubmitErrors uint64
+	Partial      uint64
+	Invalid      uint64
+	Duplicates   uint64
+	Submissions  SubmissionStats
+	Reconnects   uint64
+	WorkLatency  time.Duration
+	Connected    bool
+	Threads      int
+}
+
+// NewMetricsHandler serves GET /metrics in the Prometheus text exposition
+// format.
+func NewMetricsHandler(stats func() MetricsStats) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		s := stats()
+		var buf bytes.Buffer
+		metric := func(name, kind, help string) {
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		}
+		metric("quai_miner_hashrate", "gauge", "Current hashrate in hashes per second.")
+		fmt.Fprintf(&buf, "quai_miner_hashrate %g\n", s.Hashrate)
+		metric("quai_miner_hashrate_ema", "gauge", "Exponential moving average of the hashrate, by time constant.")
+		fmt.Fprintf(&buf, "quai_miner_hashrate_ema{window=\"1m\"} %g\n", s.Hashrates.EMA1m)
+		fmt.Fprintf(&buf, "quai_miner_hashrate_ema{window=\"15m\"} %g\n", s.Hashrates.EMA15m)
+		fmt.Fprintf(&buf, "quai_miner_hashrate_ema{window=\"1h\"} %g\n", s.Hashrates.EMA1h)
+		metric("quai_miner_hashrate_peak", "gauge", "Highest hashrate sampled this session.")
+		fmt.Fprintf(&buf, "quai_miner_hashrate_peak %g\n", s.Hashrates.Peak)
+		metric("quai_miner_hashrate_average", "gauge", "Average of the hashrate samples this session.")
+		fmt.Fprintf(&buf, "quai_miner_hashrate_average %g\n", s.Hashrates.Average)
+		metric("quai_miner_blocks_found_total", "counter", "Blocks found, by context.")
+		for ctx, blocks := range s.Blocks {
+			fmt.Fprintf(&buf, "quai_miner_blocks_found_total{context=%q} %d\n", ContextName(ctx), blocks)
+		}
+		metric("quai_miner_submission_errors_total", "counter", "Submissions that failed or were rejected.")
+		fmt.Fprintf(&buf, "quai_miner_submission_errors_total %d\n", s.SubmitErrors)
+		metric("quai_miner_submissions_total", "counter", "Submission outcomes: accepted, rejected, or stale when newer work had replaced theirs.")
+		fmt.Fprintf(&buf, "quai_miner_submissions_total{result=\"accepted\"} %d\n", s.Submissions.Accepted)
+		fmt.Fprintf(&buf, "quai_miner_submissions_total{result=\"rejected\"} %d\n", s.Submissions.Rejected)
+		fmt.Fprintf(&buf, "quai_miner_submissions_total{result=\"stale\"} %d\n", s.Submissions.Stale)
+		metric("quai_miner_partial_submissions_total", "counter", "Blocks accepted by some but not all of their node contexts.")
+		fmt.Fprintf(&buf, "quai_miner_partial_submissions_total %d\n", s.Partial)
+		metric("quai_miner_invalid_solutions_total", "counter", "Solutions from the engine that failed local verification and weren't submitted.")
+		fmt.Fprintf(&buf, "quai_miner_invalid_solutions_total %d\n", s.Invalid)
+		metric("quai_miner_duplicate_solutions_total", "counter", "Solutions for already submitted work that weren't submitted again.")
+		fmt.Fprintf(&buf, "quai_miner_duplicate_solutions_total %d\n", s.Duplicates)
+		metric("quai_miner_reconnects_total", "counter", "Reconnections to the work source.")
+		fmt.Fprintf(&buf, "quai_miner_reconnects_total %d\n", s.Reconnects)
+		metric("quai_miner_work_latency_seconds", "gauge", "Age of the latest work when sealing started on it.")
+		fmt.Fprintf(&buf, "quai_miner_work_latency_seconds %g\n", s.WorkLatency.Seconds())
+		metric("quai_miner_connected", "gauge", "1 while connected to the work source.")
+		fmt.Fprintf(&buf, "quai_miner_connected %d\n", boolInt(s.Connected))
+		metric("quai_miner_threads", "gauge", "Sealing threads.")
+		fmt.Fprintf(&buf, "quai_miner_threads %d\n", s.Threads)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+	return mux
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -2,99 +2,275 @@ package util
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net"
-	"net/netip"
 	"sync"
+	"time"
 
 	"github.com/INFURA/go-ethlibs/jsonrpc"
 
 	"github.com/dominant-strategies/go-quai-stratum/rpc"
 	"github.com/dominant-strategies/go-quai/core/types"
+	log "github.com/sirupsen/logrus"
 )
 
 type MinerSession struct {
-	proto string
-	ip    netip.Addr
-	port  int
-	conn  *net.TCPConn
-	enc   *json.Encoder
+	proto  string
+	remote string
+	conn   net.Conn
+	reader *bufio.Reader
+	enc    *json.Encoder
+
+	// Method naming the proxy speaks
+	dialect string
 
 	// Stratum
 	sync.Mutex
 	latestId uint64
+
+	// Calls awaiting their reply, by request ID
+	pendingLock sync.Mutex
+	pending     map[uint64]*Call
+
+	// Header fields offered by the proxy, used to shape submissions
+	fieldsLock   sync.RWMutex
+	headerFields map[string]bool
 }
 
+// Header fields a mined header submission always carries.
+var sealFields = map[string]bool{"hash": true, "nonce": true, "mixHash": true}
+
 const (
 	c_Max_Req_Size = 4096
+	// Largest message accepted from the proxy. Headers are a few KB.
+	c_Max_Msg_Size = 64 << 10
+	// Consecutive malformed messages tolerated before dropping the session.
+	c_Max_Bad_Messages = 16
+	// Time a proxy has to complete the TLS handshake.
+	c_TLS_Handshake_Timeout = 10 * time.Second
 )
 
-func NewMinerConn(endpoint string) (*MinerSession, error) {
-	remoteaddr, err := net.ResolveTCPAddr("tcp", endpoint)
+var (
+	errFrameTooLarge      = errors.New("message too large")
+	errTooManyBadMessages = errors.New("too many malformed messages from proxy")
+)
+
+// NewMinerConn dials the proxy with dialer. A non-nil tlsConfig wraps the
+// connection in (mutual) TLS, a positive keepAlive sets the TCP keepalive
+// period.
+func NewMinerConn(endpoint string, dialer *Dialer, tlsConfig *tls.Config, keepAlive time.Duration) (*MinerSession, error) {
+	server, err := dialer.DialContext(context.Background(), "tcp", endpoint)
 	if err != nil {
-		log.Fatalf("Error: %v", err)
-		panic(err)
+		return nil, err
+	}
+	if tcp, ok := server.(*net.TCPConn); ok && keepAlive > 0 {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(keepAlive)
 	}
 
-	server, err := net.DialTCP("tcp", nil, remoteaddr)
-	if err != nil {
-		log.Fatalf("Error: %v", err)
-		panic(err)
+	log.Debugf("New TCP client made to: %v", endpoint)
+
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(endpoint)
+		}
+		tlsConn := tls.Client(server, tlsConfig)
+		ctx, cancel := context.WithTimeout(context.Background(), c_TLS_Handshake_Timeout)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			server.Close()
+			return nil, err
+		}
+		log.Debugf("TLS session established with: %v", tlsConfig.ServerName)
+		return &MinerSession{proto: "tls", remote: endpoint, conn: tlsConn, reader: bufio.NewReaderSize(tlsConn, c_Max_Req_Size), enc: json.NewEncoder(tlsConn), dialect: DialectQuai}, nil
 	}
-	
-	log.Printf("New TCP client made to: %v", server.RemoteAddr().String())
 
-	return &MinerSession{proto: "tcp", ip: remoteaddr.AddrPort().Addr(), port: remoteaddr.Port, conn: server, latestId: 0, enc: json.NewEncoder(server)}, nil
+	return &MinerSession{proto: "tcp", remote: endpoint, conn: server, reader: bufio.NewReaderSize(server, c_Max_Req_Size), enc: json.NewEncoder(server), dialect: DialectQuai}, nil
 }
 
 // Reads raw data from TCP connection expecting a header to unmarshal.
-// Puts received header into updateCh.
+// Puts received header into updateCh and hands replies to the calls awaiting
+// them. Malformed or oversized messages are dropped; the session is only
+// abandoned once the proxy keeps misbehaving.
 func (miner *MinerSession) ListenTCP(updateCh chan *types.Header) error {
-	connbuff := bufio.NewReaderSize(miner.conn, c_Max_Req_Size)
+	connbuff := miner.reader
+	defer miner.failPending()
 
+	badMessages := 0
 	for {
-		data, isPrefix, err := connbuff.ReadLine()
-		if isPrefix {
-			log.Printf("Socket flood detected from %s", miner.ip)
-			return err
+		if badMessages >= c_Max_Bad_Messages {
+			log.Warnf("Too many malformed messages from %s, closing session", miner.remote)
+			miner.conn.Close()
+			return errTooManyBadMessages
+		}
+		data, err := readFrame(connbuff, c_Max_Msg_Size)
+		if err == errFrameTooLarge {
+			log.Warnf("Dropped message over %d bytes from %s", c_Max_Msg_Size, miner.remote)
+			badMessages++
+			continue
 		} else if err == io.EOF {
-			log.Printf("Client %s disconnected", miner.ip)
+			if len(data) > 0 {
+				log.Warnf("Client %s disconnected mid-message, dropped %d bytes", miner.remote, len(data))
+			} else {
+				log.Printf("Client %s disconnected", miner.remote)
+			}
 			return nil
 		} else if err != nil {
-			log.Printf("Error reading from socket: %v", err)
+			log.Errorf("Error reading from socket: %v", err)
 			return err
 		}
+		if len(data) == 0 {
+			continue
+		}
+		data = chaosCorrupt(data)
 
-		if len(data) > 1 {
-			var rpcResp *rpc.JsonRPCResponse
-			err := json.Unmarshal(data, &rpcResp)
-			if err != nil {
-				log.Printf("Unable to decode RPC Response: %v", err)
-				return err
+		resp, header, raw, err := decodeHeaderMessage(data)
+		if err != nil {
+			log.Warnf("Dropped message from %s: %v", miner.remote, err)
+			badMessages++
+			continue
+		}
+		badMessages = 0
+		miner.recordReply(resp)
+		if header == nil {
+			// Replies without work, such as login or submission acks.
+			continue
+		}
+		miner.recordHeaderFields(raw)
+
+		updateCh <- header
+	}
+}
+
+// readFrame reads one newline delimited message of at most limit bytes. An
+// oversized message is discarded up to its newline so framing recovers on
+// the next one. On EOF the partial message read so far is returned.
+func readFrame(r *bufio.Reader, limit int) ([]byte, error) {
+	var frame []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(frame)+len(chunk) > limit {
+			for err == bufio.ErrBufferFull {
+				_, err = r.ReadSlice('\n')
 			}
-			if rpcResp.Error != nil {
-				log.Printf("Error received from proxy: %v", rpcResp.Error.Message)
-				return errors.New(rpcResp.Error.Message)
+			if err != nil {
+				return nil, err
 			}
+			return nil, errFrameTooLarge
+		}
+		frame = append(frame, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return frame, err
+		}
+		return bytes.TrimSpace(frame), nil
+	}
+}
 
-			var header *types.Header
-			err = json.Unmarshal(*rpcResp.Result, &header)
-			if err != nil {
-				log.Printf("Unable to decode header: %v", err)
-				return err
+// decodeHeaderMessage strictly decodes a single JSON-RPC message. It returns
+// a nil header for messages that don't carry work.
+func decodeHeaderMessage(data []byte) (*rpc.JsonRPCResponse, *types.Header, json.RawMessage, error) {
+	if data[0] != '{' {
+		return nil, nil, nil, errors.New("not a JSON object")
+	}
+	var rpcResp *rpc.JsonRPCResponse
+	if err := json.Unmarshal(data, &rpcResp); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to decode RPC response: %v", err)
+	}
+	if rpcResp.Error != nil || rpcResp.Result == nil || !bytes.HasPrefix(*rpcResp.Result, []byte("{")) {
+		return rpcResp, nil, nil, nil
+	}
+	var header *types.Header
+	if err := json.Unmarshal(*rpcResp.Result, &header); err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to decode header: %v", err)
+	}
+	return rpcResp, header, *rpcResp.Result, nil
+}
+
+// recordReply delivers a reply to the call awaiting it and logs replies that
+// aren't work, such as login and submission acks.
+func (miner *MinerSession) recordReply(resp *rpc.JsonRPCResponse) {
+	call := miner.deliver(resp)
+	fields := EventFields{"context": "proxy", "id": resp.ID}
+	if call != nil {
+		fields["method"] = call.Method
+	}
+	if resp.Error != nil {
+		log.Warnf("Error received from proxy: %v", resp.Error.Message)
+		fields["accepted"] = false
+		fields["error"] = resp.Error.Message
+		LogEvent(EventAck, fields)
+	} else if resp.Result != nil && bytes.HasPrefix(*resp.Result, []byte("[")) {
+		log.Errorf("Proxy answered with array getwork, which carries no Quai header to mine on")
+	} else if resp.Result == nil || !bytes.HasPrefix(*resp.Result, []byte("{")) {
+		fields["result"] = resp.Result
+		LogEvent(EventAck, fields)
+	}
+}
+
+// recordHeaderFields remembers which header fields the proxy speaks and warns
+// about fields this miner doesn't know.
+func (miner *MinerSession) recordHeaderFields(raw json.RawMessage) {
+	var offered map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &offered); err != nil {
+		return
+	}
+	fields := make(map[string]bool, len(offered))
+	for field := range offered {
+		fields[field] = true
+	}
+	miner.fieldsLock.Lock()
+	defer miner.fieldsLock.Unlock()
+	if miner.headerFields == nil {
+		known := types.EmptyHeader().RPCMarshalHeader()
+		for field := range fields {
+			if _, ok := known[field]; !ok {
+				log.Warnf("Proxy header has field %q unknown to this miner", field)
 			}
+		}
+	}
+	miner.headerFields = fields
+}
 
-			updateCh <- header
+// MarshalHeader marshals the header for submission, leaving out fields the
+// proxy's header format doesn't have so upstream schema changes don't break
+// submissions.
+func (miner *MinerSession) MarshalHeader(header *types.Header) map[string]interface{} {
+	marshaled := header.RPCMarshalHeader()
+	miner.fieldsLock.RLock()
+	defer miner.fieldsLock.RUnlock()
+	if miner.headerFields == nil {
+		return marshaled
+	}
+	for field := range marshaled {
+		if !miner.headerFields[field] && !sealFields[field] {
+			delete(marshaled, field)
 		}
 	}
+	return marshaled
+}
+
+// Close closes the connection to the proxy, ending ListenTCP.
+func (ms *MinerSession) Close() error {
+	return ms.conn.Close()
 }
 
 func (ms *MinerSession) SendTCPRequest(msg jsonrpc.Request) error {
 	ms.Lock()
 	defer ms.Unlock()
 
+	if method, ok := dialectMethods[ms.dialect][msg.Method]; ok {
+		msg.Method = method
+	}
 	return ms.enc.Encode(msg)
 }
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"fmt"
+
+	"github.com/dominant-strategies/go-quai/params"
+)
+
+// Default go-quai websocket ports, the same on every network: the prime
+// node's, each region's and each zone's by region and zone.
+const c_Prime_WS_Port = 8547
+
+var (
+	regionWSPorts = []int{8579, 8581, 8583}
+	zoneWSPorts   = [][]int{{8611, 8643, 8675}, {8613, 8645, 8677}, {8615, 8647, 8679}}
+)
+
+// c_Default_Proxy_URL is where go-quai-stratum listens for miners by default.
+const c_Default_Proxy_URL = "127.0.0.1:8008"
+
+// Networks are the Quai networks selectable with --network, by the chain
+// config their nodes run.
+var Networks = map[string]*params.ChainConfig{
+	"colosseum": params.ColosseumChainConfig,
+	"garden":    params.GardenChainConfig,
+	"orchard":   params.OrchardChainConfig,
+	"local":     params.LocalChainConfig,
+}
+
+// ApplyNetwork fills the node URLs and the proxy endpoint left empty in the
+// config with the defaults of a go-quai node and go-quai-stratum proxy
+// running locally on Network. Anything set explicitly is kept.
+func (c *Config) ApplyNetwork() error {
+	if c.Network == "" {
+		return nil
+	}
+	if _, ok := Networks[c.Network]; !ok {
+		return fmt.Errorf("unknown network %q", c.Network)
+	}
+	if c.PrimeURL == "" {
+		c.PrimeURL = localWS(c_Prime_WS_Port)
+	}
+	if len(c.RegionURLs) == 0 {
+		for _, port := range regionWSPorts {
+			c.RegionURLs = append(c.RegionURLs, localWS(port))
+		}
+	}
+	if len(c.ZoneURLs) == 0 {
+		for _, ports := range zoneWSPorts {
+			var urls []string
+			for _, port := range ports {
+				urls = append(urls, localWS(port))
+			}
+			c.ZoneURLs = append(c.ZoneURLs, urls)
+		}
+	}
+	if c.ProxyURL == "" && len(c.ProxyURLs) == 0 {
+		c.ProxyURL = c_Default_Proxy_URL
+	}
+	return nil
+}
+
+func localWS(port int) string {
+	return fmt.Sprintf("ws://127.0.0.1:%d", port)
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+)
+
+const c_Node_Location_Timeout = 3 * time.Second
+
+// NodeLocation asks the node at url which location it runs.
+func NodeLocation(url string, dialer *Dialer) (common.Location, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c_Node_Location_Timeout)
+	defer cancel()
+	client, err := dialer.DialNode(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	var indices []hexutil.Uint64
+	if err := client.CallContext(ctx, &indices, "quai_nodeLocation"); err != nil {
+		return nil, err
+	}
+	location := make(common.Location, len(indices))
+	for i, index := range indices {
+		location[i] = byte(index)
+	}
+	return location, nil
+}
+
+// DetectLocation asks every configured zone node for its location and returns
+// the location of the only one answering. A node reporting a location other
+// than the one its URL is configured for is an error, as is more than one
+// node answering.
+func DetectLocation(config Config) (common.Location, error) {
+	dialer, err := NewDialer(config.OutboundProxy)
+	if err != nil {
+		return nil, err
+	}
+	type answer struct {
+		url      string
+		slot     common.Location
+		location common.Location
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		answers []answer
+	)
+	for r, urls := range config.ZoneURLs {
+		for z, url := range urls {
+			if url == "" {
+				continue
+			}
+			wg.Add(1)
+			go func(url string, slot common.Location) {
+				defer wg.Done()
+				location, err := NodeLocation(url, dialer)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				answers = append(answers, answer{url, slot, location})
+				mu.Unlock()
+			}(url, common.Location{byte(r), byte(z)})
+		}
+	}
+	wg.Wait()
+	for _, a := range answers {
+		if !a.location.Equal(a.slot) {
+			return nil, fmt.Errorf("zone node at %s runs location %v but is configured as ZoneURLs[%d][%d]", a.url, a.location, a.slot.Region(), a.slot.Zone())
+		}
+	}
+	switch len(answers) {
+	case 0:
+		return nil, errors.New("none of the configured zone nodes answered")
+	case 1:
+		return answers[0].location, nil
+	default:
+		var locations []common.Location
+		for _, a := range answers {
+			locations = append(locations, a.location)
+		}
+		return nil, fmt.Errorf("zone nodes for %v all answered, pick one with Location or --region/--zone", locations)
+	}
+}
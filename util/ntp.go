@@ -0,0 +1,58 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// Seconds from the NTP epoch (1900) to the Unix epoch.
+const c_NTP_Epoch_Offset = 2208988800
+
+// ClockOffset asks the SNTP server how far the local clock is off, positive
+// when it is behind.
+func ClockOffset(server string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // version 4, client mode
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], ntpTime(sent))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	if n < 48 || resp[0]&0x07 != 4 {
+		return 0, errors.New("malformed NTP response")
+	}
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// ntpTime encodes t as NTP seconds and fraction.
+func ntpTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + c_NTP_Epoch_Offset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - c_NTP_Epoch_Offset
+	nanos := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
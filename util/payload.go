@@ -0,0 +1,54 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// payloadSecret is the shared secret outbound payloads are signed with.
+var payloadSecret []byte
+
+// SetPayloadSecret enables HMAC signing of all outbound webhook, telemetry
+// and Influx payloads.
+func SetPayloadSecret(secret string) {
+	payloadSecret = []byte(secret)
+}
+
+// SignPayload returns the hex HMAC-SHA256 of "timestamp.body" under secret.
+func SignPayload(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newJSONRequest builds a JSON POST request. When a payload secret is set the
+// request carries X-Signature-Timestamp and X-Signature headers so receivers
+// can verify it came from this rig and reject replays.
+func newJSONRequest(ctx context.Context, url string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signRequest(req, body)
+	return req, nil
+}
+
+// signRequest adds the X-Signature-Timestamp and X-Signature headers for body
+// when a payload secret is set.
+func signRequest(req *http.Request, body []byte) {
+	if len(payloadSecret) == 0 {
+		return
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature", "sha256="+SignPayload(payloadSecret, timestamp, body))
+}
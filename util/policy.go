@@ -0,0 +1,121 @@
+package util
+
+import (
+	"fmt"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Upper bound on Starlark steps per evaluation, so a looping script can't
+// stall the miner.
+const c_Policy_Max_Steps = 1_000_000
+
+// PolicyState is the live miner state a policy script is evaluated against.
+type PolicyState struct {
+	Hashrate      float64
+	Temperature   float64
+	Load          float64
+	Threads       int
+	Cores         int
+	Paused        bool
+	Connected     bool
+	Blocks        [3]uint64
+	Hour          int
+	Weekday       int
+	RewardAddress string
+}
+
+// PolicyDecision is what a policy asked for. Nil fields leave the miner's
+// current setting alone.
+type PolicyDecision struct {
+	Pause   *bool
+	Threads *int
+}
+
+// Policy is a loaded Starlark policy script. The script defines
+//
+//	def policy(state):
+//	    return {"pause": state.temperature > 85, "threads": 4}
+//
+// where state carries the PolicyState fields in snake case. Returning None
+// or omitting a key leaves that setting unchanged.
+type Policy struct {
+	path string
+	fn   starlark.Callable
+}
+
+// LoadPolicy executes the script at path and looks up its policy function.
+func LoadPolicy(path string) (*Policy, error) {
+	thread := &starlark.Thread{Name: "policy"}
+	thread.SetMaxExecutionSteps(c_Policy_Max_Steps)
+	globals, err := starlark.ExecFile(thread, path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := globals["policy"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("%s does not define a policy(state) function", path)
+	}
+	return &Policy{path: path, fn: fn}, nil
+}
+
+// Evaluate calls the policy with the given state.
+func (p *Policy) Evaluate(state PolicyState) (PolicyDecision, error) {
+	var decision PolicyDecision
+	blocks := starlark.NewList([]starlark.Value{
+		starlark.MakeUint64(state.Blocks[0]),
+		starlark.MakeUint64(state.Blocks[1]),
+		starlark.MakeUint64(state.Blocks[2]),
+	})
+	arg := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"hashrate":       starlark.Float(state.Hashrate),
+		"temperature":    starlark.Float(state.Temperature),
+		"load":           starlark.Float(state.Load),
+		"threads":        starlark.MakeInt(state.Threads),
+		"cores":          starlark.MakeInt(state.Cores),
+		"paused":         starlark.Bool(state.Paused),
+		"connected":      starlark.Bool(state.Connected),
+		"blocks":         blocks,
+		"hour":           starlark.MakeInt(state.Hour),
+		"weekday":        starlark.MakeInt(state.Weekday),
+		"reward_address": starlark.String(state.RewardAddress),
+	})
+	thread := &starlark.Thread{Name: "policy"}
+	thread.SetMaxExecutionSteps(c_Policy_Max_Steps)
+	result, err := starlark.Call(thread, p.fn, starlark.Tuple{arg}, nil)
+	if err != nil {
+		return decision, err
+	}
+	if result == starlark.None {
+		return decision, nil
+	}
+	dict, ok := result.(*starlark.Dict)
+	if !ok {
+		return decision, fmt.Errorf("policy returned %s, want dict or None", result.Type())
+	}
+	for _, item := range dict.Items() {
+		key, _ := starlark.AsString(item[0])
+		value := item[1]
+		if value == starlark.None {
+			continue
+		}
+		switch key {
+		case "pause":
+			pause := bool(value.Truth())
+			decision.Pause = &pause
+		case "threads":
+			threads, err := starlark.AsInt32(value)
+			if err != nil {
+				return decision, fmt.Errorf("policy threads: %v", err)
+			}
+			if threads < 1 {
+				return decision, fmt.Errorf("policy threads must be a positive integer, got %d", threads)
+			}
+			decision.Threads = &threads
+		default:
+			return decision, fmt.Errorf("policy returned unknown action %q", key)
+		}
+	}
+	return decision, nil
+}
@@ -0,0 +1,18 @@
+package util
+
+// Preset bundles the tuning knobs behind a single intensity name.
+// CoreShare is the percentage of usable cores to mine on, DutyCycle the
+// percentage of each sealing thread's time spent hashing, like CPUPercent,
+// and Nice the process priority.
+type Preset struct {
+	CoreShare float64
+	DutyCycle float64
+	Nice      int
+}
+
+// Presets are the named intensity presets selectable with --preset.
+var Presets = map[string]Preset{
+	"eco":      {CoreShare: 25, DutyCycle: 50, Nice: 19},
+	"balanced": {CoreShare: 50, DutyCycle: 80, Nice: 10},
+	"max":      {CoreShare: 100, DutyCycle: 100, Nice: 0},
+}
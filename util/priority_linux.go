@@ -0,0 +1,13 @@
+//go:build linux
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// SetPriority sets the nice value of every thread in the process, since Linux
+// tracks priority per thread.
+func SetPriority(nice int) error {
+	return forEachThread(func(tid int) error {
+		return unix.Setpriority(unix.PRIO_PROCESS, tid, nice)
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// SetPriority sets the nice value of the process.
+func SetPriority(nice int) error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, nice)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package util
+
+import "golang.org/x/sys/windows"
+
+// SetPriority maps a unix nice value onto a Windows process priority class.
+func SetPriority(nice int) error {
+	class := uint32(windows.NORMAL_PRIORITY_CLASS)
+	if nice >= 15 {
+		class = windows.IDLE_PRIORITY_CLASS
+	} else if nice > 0 {
+		class = windows.BELOW_NORMAL_PRIORITY_CLASS
+	}
+	return windows.SetPriorityClass(windows.CurrentProcess(), class)
+}
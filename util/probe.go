@@ -0,0 +1,229 @@
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/INFURA/go-ethlibs/jsonrpc"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+// ProbeStatus is the outcome of a single proxy conformance check.
+type ProbeStatus string
+
+const (
+	ProbePass ProbeStatus = "PASS"
+	ProbeWarn ProbeStatus = "WARN"
+	ProbeFail ProbeStatus = "FAIL"
+	ProbeSkip ProbeStatus = "SKIP"
+)
+
+// ProbeResult records how the proxy behaved for one part of the protocol.
+type ProbeResult struct {
+	Check  string
+	Status ProbeStatus
+	Detail string
+}
+
+const c_Probe_Reply_Timeout = 10 * time.Second
+
+var (
+	errProbeTimeout = errors.New("no reply")
+	errProbeClosed  = errors.New("connection closed by proxy")
+)
+
+// proxyProber drives a proxy session by hand, reading replies synchronously
+// instead of through ListenTCP.
+type proxyProber struct {
+	session *MinerSession
+	reader  *bufio.Reader
+	id      int
+	results []ProbeResult
+}
+
+// ProbeProxy exercises the proxy protocol on a fresh session: login, pending
+// header request, pushed work and the rejection of a bogus solution. The
+// session is left unusable for mining afterwards.
+func ProbeProxy(session *MinerSession, loginParams []interface{}, pushWait time.Duration) []ProbeResult {
+	p := &proxyProber{session: session, reader: session.reader}
+
+	// Login. The proxy may answer with work straight away, or stay silent.
+	loginID, err := p.send("quai_submitLogin", loginParams...)
+	if err != nil {
+		p.record("login", ProbeFail, fmt.Sprintf("unable to send: %v", err))
+		return p.results
+	}
+	resp, err := p.read(c_Probe_Reply_Timeout)
+	var header *types.Header
+	switch {
+	case err == errProbeTimeout:
+		p.record("login", ProbePass, "accepted without reply")
+	case err != nil:
+		p.record("login", ProbeFail, err.Error())
+		return p.results
+	case resp.Error != nil:
+		p.record("login", ProbeFail, fmt.Sprintf("rejected: %s", resp.Error.Message))
+		return p.results
+	default:
+		p.record("login", ProbePass, "accepted")
+		p.checkID("login reply id", resp, loginID)
+		header, _ = p.decodeHeader("login work", resp)
+	}
+
+	// Pending header on request.
+	headerID, err := p.send("quai_getPendingHeader", nil)
+	if err != nil {
+		p.record("getPendingHeader", ProbeFail, fmt.Sprintf("unable to send: %v", err))
+		return p.results
+	}
+	resp, err = p.read(c_Probe_Reply_Timeout)
+	switch {
+	case err != nil:
+		p.record("getPendingHeader", ProbeFail, err.Error())
+		return p.results
+	case resp.Error != nil:
+		p.record("getPendingHeader", ProbeFail, fmt.Sprintf("error reply: %s", resp.Error.Message))
+	default:
+		p.checkID("getPendingHeader reply id", resp, headerID)
+		if pending, ok := p.decodeHeader("getPendingHeader", resp); ok {
+			header = pending
+			p.record("getPendingHeader", ProbePass, fmt.Sprintf("header %v", header.NumberArray()))
+		}
+	}
+
+	// Work pushed without being asked for.
+	if pushWait > 0 {
+		resp, err = p.read(pushWait)
+		switch {
+		case err == errProbeTimeout:
+			p.record("push", ProbeWarn, fmt.Sprintf("no new work pushed within %v", pushWait))
+		case err != nil:
+			p.record("push", ProbeFail, err.Error())
+			return p.results
+		case resp.Error != nil:
+			p.record("push", ProbeFail, fmt.Sprintf("error pushed: %s", resp.Error.Message))
+		default:
+			if pushed, ok := p.decodeHeader("push", resp); ok {
+				if header != nil && pushed.SealHash() == header.SealHash() {
+					p.record("push", ProbeWarn, "pushed work is identical to the previous header")
+				} else {
+					p.record("push", ProbePass, fmt.Sprintf("header %v", pushed.NumberArray()))
+				}
+				header = pushed
+			}
+		}
+	} else {
+		p.record("push", ProbeSkip, "push wait disabled")
+	}
+
+	// A solution with a nonce that can't meet the target must be refused.
+	if header == nil {
+		p.record("invalid submission", ProbeSkip, "no work to submit against")
+		return p.results
+	}
+	bogus := types.CopyHeader(header)
+	bogus.SetNonce(types.EncodeNonce(invalidNonce(bogus)))
+	submitID, err := p.send("quai_receiveMinedHeader", session.MarshalHeader(bogus))
+	if err != nil {
+		p.record("invalid submission", ProbeFail, fmt.Sprintf("unable to send: %v", err))
+		return p.results
+	}
+	for {
+		resp, err = p.read(c_Probe_Reply_Timeout)
+		switch {
+		case err == errProbeTimeout:
+			p.record("invalid submission", ProbeWarn, "no rejection reported")
+		case errors.Is(err, errProbeClosed):
+			p.record("invalid submission", ProbeWarn, "proxy dropped the connection instead of replying")
+		case err != nil:
+			p.record("invalid submission", ProbeFail, err.Error())
+		case resp.Error != nil:
+			p.record("invalid submission", ProbePass, fmt.Sprintf("rejected: %s", resp.Error.Message))
+			p.checkID("rejection reply id", resp, submitID)
+		case resp.Result != nil && string(*resp.Result) == "false":
+			p.record("invalid submission", ProbePass, "rejected")
+		case resp.Result != nil && string(*resp.Result) == "true":
+			p.record("invalid submission", ProbeFail, "accepted an invalid solution")
+		default:
+			// Work pushed in the meantime, keep waiting for the verdict.
+			continue
+		}
+		return p.results
+	}
+}
+
+// invalidNonce returns a nonce that isn't the header's own. Against any real
+// difficulty the odds of it solving the header are negligible.
+func invalidNonce(header *types.Header) uint64 {
+	return ^header.NonceU64()
+}
+
+func (p *proxyProber) record(check string, status ProbeStatus, detail string) {
+	p.results = append(p.results, ProbeResult{Check: check, Status: status, Detail: detail})
+}
+
+func (p *proxyProber) send(method string, params ...interface{}) (int, error) {
+	p.id++
+	msg, err := jsonrpc.MakeRequest(p.id, method, params...)
+	if err != nil {
+		return p.id, err
+	}
+	return p.id, p.session.SendTCPRequest(*msg)
+}
+
+// read waits for the next message from the proxy.
+func (p *proxyProber) read(timeout time.Duration) (*rpc.JsonRPCResponse, error) {
+	p.session.conn.SetReadDeadline(time.Now().Add(timeout))
+	defer p.session.conn.SetReadDeadline(time.Time{})
+	for {
+		data, err := readFrame(p.reader, c_Max_Msg_Size)
+		if err == errFrameTooLarge {
+			return nil, fmt.Errorf("message exceeds %d bytes", c_Max_Msg_Size)
+		}
+		if err != nil {
+			var netErr interface{ Timeout() bool }
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, errProbeTimeout
+			}
+			return nil, fmt.Errorf("%w: %v", errProbeClosed, err)
+		}
+		if len(data) == 0 {
+			continue
+		}
+		var resp *rpc.JsonRPCResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, fmt.Errorf("malformed reply: %v", err)
+		}
+		return resp, nil
+	}
+}
+
+// checkID warns when the proxy doesn't echo request ids, which makes replies
+// impossible to correlate.
+func (p *proxyProber) checkID(check string, resp *rpc.JsonRPCResponse, id int) {
+	var got int
+	if len(resp.ID) == 0 || json.Unmarshal(resp.ID, &got) != nil || got != id {
+		p.record(check, ProbeWarn, fmt.Sprintf("expected %d, got %q", id, string(resp.ID)))
+	}
+}
+
+func (p *proxyProber) decodeHeader(check string, resp *rpc.JsonRPCResponse) (*types.Header, bool) {
+	if resp.Result == nil {
+		p.record(check, ProbeFail, "reply has no result")
+		return nil, false
+	}
+	var header *types.Header
+	if err := json.Unmarshal(*resp.Result, &header); err != nil || header == nil {
+		p.record(check, ProbeFail, fmt.Sprintf("result is not a header: %v", err))
+		return nil, false
+	}
+	if header.Difficulty() == nil || header.Difficulty().Sign() <= 0 {
+		p.record(check, ProbeWarn, "header has no difficulty")
+	}
+	return header, true
+}
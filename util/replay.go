@@ -0,0 +1,236 @@
+package util
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// c_Replay_Linger is how long the last replayed work keeps being served, so
+// the miner gets to seal it and submit late solutions.
+const c_Replay_Linger = 5 * time.Second
+
+// ReplayEvent is a recorded work package or submission, at its offset from
+// the start of the recording.
+type ReplayEvent struct {
+	Offset time.Duration
+	Kind   string
+	Target string
+	Header *types.Header
+}
+
+// LoadRecording reads the events of a --record file, or of the capture in a
+// --capture support bundle.
+func LoadRecording(path string) ([]ReplayEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in := bufio.NewReader(f)
+	var r io.Reader = in
+	if magic, _ := in.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		if r, err = bundleCapture(in); err != nil {
+			return nil, err
+		}
+	}
+	var events []ReplayEvent
+	dec := json.NewDecoder(r)
+	for {
+		var record CaptureRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(events)+1, err)
+		}
+		raw, err := json.Marshal(record.Header)
+		if err != nil {
+			return nil, err
+		}
+		var header *types.Header
+		if err := json.Unmarshal(raw, &header); err != nil {
+			return nil, fmt.Errorf("record %d: %w", len(events)+1, err)
+		}
+		events = append(events, ReplayEvent{
+			Offset: time.Duration(record.Mono),
+			Kind:   record.Kind,
+			Target: record.Target,
+			Header: header,
+		})
+	}
+	return events, nil
+}
+
+// bundleCapture finds capture.jsonl in a gzipped support bundle.
+func bundleCapture(r io.Reader) (io.Reader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("bundle has no capture.jsonl")
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Name == "capture.jsonl" {
+			return tr, nil
+		}
+	}
+}
+
+// ReplayWorkSource is an in-process getwork endpoint serving recorded work
+// at its recorded pacing, sped up by a factor. Solutions are verified against
+// the replayed work and logged, and the submissions of the original session
+// are logged when their turn comes, so the two can be compared.
+type ReplayWorkSource struct {
+	engine   PowEngine
+	listener net.Listener
+	events   []ReplayEvent
+	speed    float64
+
+	mu     sync.Mutex
+	recent []*types.Header // oldest first
+
+	// Done is closed once the last event has been replayed and served for
+	// c_Replay_Linger.
+	Done chan struct{}
+}
+
+// NewReplayWorkSource starts replaying events speed times faster than
+// recorded on a loopback port.
+func NewReplayWorkSource(engine PowEngine, events []ReplayEvent, speed float64) (*ReplayWorkSource, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &ReplayWorkSource{
+		engine:   engine,
+		listener: listener,
+		events:   events,
+		speed:    speed,
+		Done:     make(chan struct{}),
+	}
+	go http.Serve(listener, s)
+	go s.run()
+	return s, nil
+}
+
+// URL is the getwork endpoint to point the miner at.
+func (s *ReplayWorkSource) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+// Close stops serving.
+func (s *ReplayWorkSource) Close() error {
+	return s.listener.Close()
+}
+
+func (s *ReplayWorkSource) run() {
+	defer close(s.Done)
+	start := time.Now()
+	for i, event := range s.events {
+		due := start.Add(time.Duration(float64(event.Offset) / s.speed))
+		time.Sleep(time.Until(due))
+		fields := log.Fields{"event": i + 1, "offset": event.Offset, "sealHash": event.Header.SealHash()}
+		switch event.Kind {
+		case "work":
+			log.WithFields(fields).Debugln("Replaying work", event.Header.NumberArray())
+			s.mu.Lock()
+			s.recent = append(s.recent, event.Header)
+			if len(s.recent) > c_Bridge_Work_History {
+				s.recent = s.recent[1:]
+			}
+			s.mu.Unlock()
+		case "submit":
+			log.WithFields(fields).Infoln("Recorded session submitted", event.Header.Hash(), "to", event.Target)
+		}
+	}
+	time.Sleep(c_Replay_Linger)
+}
+
+func (s *ReplayWorkSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := rpc.JsonRPCResponse{ID: req.ID}
+	var result interface{}
+	switch req.Method {
+	case "eth_getWork":
+		s.mu.Lock()
+		if len(s.recent) > 0 {
+			result = s.recent[len(s.recent)-1].RPCMarshalHeader()
+		} else {
+			resp.Error = &rpc.JsonError{Code: -32000, Message: "no work replayed yet"}
+		}
+		s.mu.Unlock()
+	case "eth_submitWork":
+		result = s.submit(req.Params)
+	default:
+		resp.Error = &rpc.JsonError{Code: c_Method_Not_Found, Message: "method not found"}
+	}
+	if result != nil {
+		raw, _ := json.Marshal(result)
+		msg := json.RawMessage(raw)
+		resp.Result = &msg
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// submit verifies a solution for recently replayed work and logs it.
+func (s *ReplayWorkSource) submit(params []json.RawMessage) bool {
+	if len(params) != 3 {
+		return false
+	}
+	var (
+		nonce    types.BlockNonce
+		sealHash common.Hash
+		mixHash  common.Hash
+	)
+	if json.Unmarshal(params[0], &nonce) != nil || json.Unmarshal(params[1], &sealHash) != nil || json.Unmarshal(params[2], &mixHash) != nil {
+		return false
+	}
+	var header *types.Header
+	s.mu.Lock()
+	for _, work := range s.recent {
+		if work.SealHash() == sealHash {
+			header = types.CopyHeader(work)
+		}
+	}
+	s.mu.Unlock()
+	if header == nil {
+		log.WithField("sealHash", sealHash).Warnln("Replay got a solution for work that isn't recent")
+		return false
+	}
+	header.SetNonce(nonce)
+	header.SetMixHash(&mixHash)
+	if err := VerifySolution(s.engine, header).Problem(); err != nil {
+		log.WithField("sealHash", sealHash).Warnln("Replay got an invalid solution: ", err)
+		return false
+	}
+	log.WithField("sealHash", sealHash).Infoln("Replay solved", header.Hash())
+	return true
+}
@@ -0,0 +1,59 @@
+package util
+
+import "sync"
+
+// When the reward address rotation advances: after every found block, which
+// matches the weights exactly, or on every new block height of work, which
+// splits the hashing time by weight and so approximates them.
+const (
+	RotateBlock = "block"
+	RotateJob   = "job"
+)
+
+// WeightedAddress is a reward address and its share of the rotation.
+type WeightedAddress struct {
+	Address string
+	Weight  int
+}
+
+// AddressRotator hands out reward addresses by smooth weighted round-robin:
+// over any window of total-weight picks each address comes up Weight times,
+// spread evenly rather than in runs. Equal weights give plain round-robin.
+type AddressRotator struct {
+	lock      sync.Mutex
+	addresses []WeightedAddress
+	current   []int
+	total     int
+}
+
+func NewAddressRotator(addresses []WeightedAddress) *AddressRotator {
+	r := &AddressRotator{current: make([]int, len(addresses))}
+	for _, address := range addresses {
+		if address.Weight <= 0 {
+			address.Weight = 1
+		}
+		r.addresses = append(r.addresses, address)
+		r.total += address.Weight
+	}
+	return r
+}
+
+// Next returns the next address in the rotation.
+func (r *AddressRotator) Next() string {
+	return r.addresses[r.nextIndex()].Address
+}
+
+func (r *AddressRotator) nextIndex() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	best := 0
+	for i, address := range r.addresses {
+		r.current[i] += address.Weight
+		if r.current[i] > r.current[best] {
+			best = i
+		}
+	}
+	r.current[best] -= r.total
+	return best
+}
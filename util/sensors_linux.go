@@ -0,0 +1,64 @@
+//go:build linux
+
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hwmon drivers that report CPU package or core temperatures.
+var cpuSensorNames = map[string]bool{
+	"coretemp":    true,
+	"k10temp":     true,
+	"zenpower":    true,
+	"cpu_thermal": true,
+}
+
+// CPUTemperature returns the hottest CPU temperature reported by hwmon, in
+// degrees Celsius, falling back to the first thermal zone.
+func CPUTemperature() (float64, error) {
+	hottest := 0.0
+	found := false
+	sensors, _ := filepath.Glob("/sys/class/hwmon/hwmon*")
+	for _, sensor := range sensors {
+		name, err := os.ReadFile(filepath.Join(sensor, "name"))
+		if err != nil || !cpuSensorNames[strings.TrimSpace(string(name))] {
+			continue
+		}
+		inputs, _ := filepath.Glob(filepath.Join(sensor, "temp*_input"))
+		for _, input := range inputs {
+			temp, err := readMilliCelsius(input)
+			if err != nil {
+				continue
+			}
+			found = true
+			if temp > hottest {
+				hottest = temp
+			}
+		}
+	}
+	if found {
+		return hottest, nil
+	}
+	temp, err := readMilliCelsius("/sys/class/thermal/thermal_zone0/temp")
+	if err != nil {
+		return 0, errors.New("no CPU temperature sensor found")
+	}
+	return temp, nil
+}
+
+func readMilliCelsius(path string) (float64, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return value / 1000, nil
+}
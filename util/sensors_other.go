@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package util
+
+import "errors"
+
+// CPUTemperature is only supported on Linux and Windows.
+func CPUTemperature() (float64, error) {
+	return 0, errors.New("reading CPU temperature is not supported on this platform")
+}
@@ -0,0 +1,123 @@
+//go:build windows
+
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// WMI queries for CPU temperature. LibreHardwareMonitor reports Celsius per
+// sensor, printed in the invariant culture so a decimal comma locale doesn't
+// break parsing; the ACPI thermal zone fallback reports integer tenths of a
+// Kelvin.
+const (
+	c_LHM_Query  = `Get-CimInstance -Namespace root/LibreHardwareMonitor -ClassName Sensor | Where-Object { $_.SensorType -eq 'Temperature' -and $_.Identifier -like '*cpu*' } | ForEach-Object { $_.Value.ToString([Globalization.CultureInfo]::InvariantCulture) }`
+	c_ACPI_Query = `Get-CimInstance -Namespace root/wmi -ClassName MSAcpi_ThermalZoneTemperature | ForEach-Object { $_.CurrentTemperature }`
+	// Printed after each query's output to mark its end.
+	c_WMI_End = "--end--"
+)
+
+// wmiShell is a long-lived PowerShell reading queries from stdin, so
+// temperature polls don't spawn a process each time.
+type wmiShell struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	out   *bufio.Scanner
+}
+
+var (
+	wmiLock sync.Mutex
+	wmi     *wmiShell
+)
+
+// CPUTemperature returns the hottest CPU temperature reported through WMI,
+// in degrees Celsius.
+func CPUTemperature() (float64, error) {
+	if temps, err := queryWMI(c_LHM_Query); err == nil && len(temps) > 0 {
+		return hottest(temps), nil
+	}
+	temps, err := queryWMI(c_ACPI_Query)
+	if err != nil {
+		return 0, err
+	}
+	if len(temps) == 0 {
+		return 0, errors.New("no CPU temperature sensor found")
+	}
+	return hottest(temps)/10 - 273.15, nil
+}
+
+func queryWMI(query string) ([]float64, error) {
+	wmiLock.Lock()
+	defer wmiLock.Unlock()
+	if wmi == nil {
+		shell, err := startWMIShell()
+		if err != nil {
+			return nil, err
+		}
+		wmi = shell
+	}
+	values, err := wmi.query(query)
+	if err != nil {
+		// Start a fresh shell on the next poll.
+		wmi.cmd.Process.Kill()
+		wmi.cmd.Wait()
+		wmi = nil
+	}
+	return values, err
+}
+
+func startWMIShell() (*wmiShell, error) {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &wmiShell{cmd: cmd, stdin: stdin, out: bufio.NewScanner(stdout)}, nil
+}
+
+// query runs one query and parses each line of its output as a number.
+func (s *wmiShell) query(query string) ([]float64, error) {
+	if _, err := fmt.Fprintf(s.stdin, "%s; '%s'\n", query, c_WMI_End); err != nil {
+		return nil, err
+	}
+	var values []float64
+	for s.out.Scan() {
+		line := strings.TrimSpace(s.out.Text())
+		if line == c_WMI_End {
+			return values, nil
+		}
+		value, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			continue
+		}
+		values = append(values, value)
+	}
+	if err := s.out.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func hottest(values []float64) float64 {
+	max := values[0]
+	for _, value := range values[1:] {
+		if value > max {
+			max = value
+		}
+	}
+	return max
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package util
+
+import "errors"
+
+// ControlService is only supported on Windows. Elsewhere run the miner under
+// a process manager such as systemd, which it notifies when ready.
+func ControlService(action string, args []string) error {
+	return errors.New("services are only supported on Windows, use a systemd unit with Type=notify on Linux")
+}
+
+// RunningAsService is always false outside Windows.
+func RunningAsService() bool {
+	return false
+}
+
+// RunService just runs the miner outside Windows.
+func RunService(run func(), stop func()) error {
+	run()
+	return nil
+}
@@ -0,0 +1,101 @@
+//go:build windows
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// ServiceName is the name the miner is registered under with the service
+// control manager.
+const ServiceName = "quai-cpu-miner"
+
+// ControlService installs, uninstalls, starts or stops the miner's Windows
+// service. Install registers this executable to run with args.
+func ControlService(action string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if action == "install" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		exe, err = filepath.Abs(exe)
+		if err != nil {
+			return err
+		}
+		s, err := m.CreateService(ServiceName, exe, mgr.Config{DisplayName: "Quai CPU miner", StartType: mgr.StartAutomatic}, args...)
+		if err != nil {
+			return err
+		}
+		return s.Close()
+	}
+	s, err := m.OpenService(ServiceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	switch action {
+	case "uninstall":
+		return s.Delete()
+	case "start":
+		return s.Start()
+	case "stop":
+		_, err := s.Control(svc.Stop)
+		return err
+	}
+	return fmt.Errorf("unknown service action %q", action)
+}
+
+// RunningAsService reports whether the service control manager started the
+// miner.
+func RunningAsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// RunService runs the miner under the service control manager. run mines
+// until stop is called, which happens when the service is stopped.
+func RunService(run func(), stop func()) error {
+	return svc.Run(ServiceName, serviceHandler{run, stop})
+}
+
+type serviceHandler struct {
+	run  func()
+	stop func()
+}
+
+func (h serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	done := make(chan struct{})
+	go func() {
+		h.run()
+		close(done)
+	}()
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32((15 * time.Second).Milliseconds())}
+				h.stop()
+				<-done
+				return false, 0
+			}
+		case <-done:
+			return false, 0
+		}
+	}
+}
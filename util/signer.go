@@ -0,0 +1,136 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+	"github.com/dominant-strategies/go-quai/crypto"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Signer signs proxy logins and submissions with a keystore-managed key so the
+// pool can authenticate the rig without a shared password.
+type Signer struct {
+	key *ecdsa.PrivateKey
+}
+
+// encryptedKey is the Web3 Secret Storage (v3) keystore file format.
+type encryptedKey struct {
+	Crypto struct {
+		Cipher       string `json:"cipher"`
+		CipherText   string `json:"ciphertext"`
+		CipherParams struct {
+			IV string `json:"iv"`
+		} `json:"cipherparams"`
+		KDF       string                 `json:"kdf"`
+		KDFParams map[string]interface{} `json:"kdfparams"`
+		MAC       string                 `json:"mac"`
+	} `json:"crypto"`
+}
+
+// LoadSigner decrypts the keystore file with the password.
+func LoadSigner(path string, password string) (*Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keyFile encryptedKey
+	if err := json.Unmarshal(raw, &keyFile); err != nil {
+		return nil, err
+	}
+	c := keyFile.Crypto
+	if c.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("unsupported keystore cipher %q", c.Cipher)
+	}
+	derived, err := deriveKey(c.KDF, c.KDFParams, password)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(c.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := hex.DecodeString(c.MAC)
+	if err != nil {
+		return nil, err
+	}
+	if len(derived) < 32 {
+		return nil, errors.New("derived keystore key is too short")
+	}
+	if !bytes.Equal(crypto.Keccak256(derived[16:32], cipherText), mac) {
+		return nil, errors.New("could not decrypt key with given password")
+	}
+	iv, err := hex.DecodeString(c.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("keystore iv is %d bytes, want %d", len(iv), aes.BlockSize)
+	}
+	block, err := aes.NewCipher(derived[:16])
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(cipherText))
+	cipher.NewCTR(block, iv).XORKeyStream(plain, cipherText)
+	key, err := crypto.ToECDSA(plain)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{key: key}, nil
+}
+
+func deriveKey(kdf string, params map[string]interface{}, password string) ([]byte, error) {
+	salt, err := hex.DecodeString(fmt.Sprint(params["salt"]))
+	if err != nil {
+		return nil, err
+	}
+	intParam := func(name string) int {
+		value, _ := params[name].(float64)
+		return int(value)
+	}
+	// The AES key and the MAC key take 16 bytes each.
+	if intParam("dklen") < 32 {
+		return nil, fmt.Errorf("keystore dklen %v is too short", params["dklen"])
+	}
+	switch kdf {
+	case "scrypt":
+		return scrypt.Key([]byte(password), salt, intParam("n"), intParam("r"), intParam("p"), intParam("dklen"))
+	case "pbkdf2":
+		if params["prf"] != "hmac-sha256" {
+			return nil, fmt.Errorf("unsupported pbkdf2 prf %v", params["prf"])
+		}
+		return pbkdf2.Key([]byte(password), salt, intParam("c"), intParam("dklen"), sha256.New), nil
+	default:
+		return nil, fmt.Errorf("unsupported keystore kdf %q", kdf)
+	}
+}
+
+// Address returns the address of the signing key.
+func (s *Signer) Address() common.Address {
+	return crypto.PubkeyToAddress(s.key.PublicKey)
+}
+
+// SignLogin signs keccak256(address || timestamp) for a login request.
+func (s *Signer) SignLogin(address string, timestamp int64) (hexutil.Bytes, error) {
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(timestamp))
+	return crypto.Sign(crypto.Keccak256([]byte(address), ts[:]), s.key)
+}
+
+// SignHash signs a 32 byte hash, such as a mined header's hash.
+func (s *Signer) SignHash(hash common.Hash) (hexutil.Bytes, error) {
+	return crypto.Sign(hash.Bytes(), s.key)
+}
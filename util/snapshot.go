@@ -0,0 +1,53 @@
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot is the runtime state carried across a restart. Undelivered
+// submissions don't need it, the spool is already kept on disk.
+type Snapshot struct {
+	Time         time.Time
+	BlocksFound  [3]uint64
+	DevFeeBlocks uint64
+	DevFeeTime   time.Duration
+	Tenants      []TenantStats
+}
+
+// SaveSnapshot writes the snapshot to path, replacing it atomically so a crash
+// mid-write leaves the previous snapshot intact.
+func SaveSnapshot(path string, snap Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot reads the snapshot at path. A missing file is an empty
+// snapshot.
+func LoadSnapshot(path string) (snap Snapshot, err error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return snap, nil
+	} else if err != nil {
+		return snap, err
+	}
+	err = json.Unmarshal(data, &snap)
+	return snap, err
+}
@@ -0,0 +1,379 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SNMPBaseOID roots the miner's objects in the experimental subtree
+// (RFC 1155), as the miner has no private enterprise number. All objects are
+// scalars read at <base>.<n>.0:
+//
+//	.1 hashrate             Gauge32, hashes per second
+//	.2 temperature          Integer, tenths of a degree Celsius, 0 when unknown
+//	.3 prime blocks found   Counter64, v2c only
+//	.4 region blocks found  Counter64, v2c only
+//	.5 zone blocks found    Counter64, v2c only
+//	.6 connected            Integer, 1 when connected to the work source
+//	.7 threads              Gauge32
+const SNMPBaseOID = "1.3.6.1.3.9000.1"
+
+// SNMPStats is the snapshot of miner state served over SNMP.
+type SNMPStats struct {
+	Hashrate    float64
+	Temperature float64
+	Blocks      [3]uint64
+	Connected   bool
+	Threads     int
+}
+
+// BER tags used by SNMP.
+const (
+	berInteger      = 0x02
+	berOctetString  = 0x04
+	berNull         = 0x05
+	berOID          = 0x06
+	berSequence     = 0x30
+	berGauge32      = 0x42
+	berCounter64    = 0x46
+	berNoSuchObject = 0x80
+	berEndOfMibView = 0x82
+
+	pduGetRequest     = 0xa0
+	pduGetNextRequest = 0xa1
+	pduGetResponse    = 0xa2
+
+	snmpVersion1  = 0
+	snmpVersion2c = 1
+
+	snmpNoSuchName = 2
+
+	c_Max_SNMP_Packet = 1500
+)
+
+var errBER = errors.New("malformed BER")
+
+// ServeSNMP runs a read-only SNMP v1/v2c agent answering GET and GETNEXT
+// requests for the given community. Clients outside the listener's allowlist
+// and requests with the wrong community are silently dropped. It blocks until
+// the socket fails.
+func (l Listener) ServeSNMP(community string, stats func() SNMPStats) error {
+	allowed, err := parseAllowlist(l.Allow)
+	if err != nil {
+		return fmt.Errorf("invalid snmp allowlist: %v", err)
+	}
+	base, err := parseOID(SNMPBaseOID)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", l.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	log.Debugf("Starting snmp listener on %s (allow %v)", l.Addr, l.Allow)
+
+	buf := make([]byte, c_Max_SNMP_Packet)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		client, _ := netip.AddrFromSlice(udpAddr.IP)
+		if !isAllowed(allowed, client.Unmap()) {
+			continue
+		}
+		resp, err := handleSNMP(buf[:n], community, base, stats)
+		if err != nil {
+			continue
+		}
+		conn.WriteTo(resp, addr)
+	}
+}
+
+// snmpObject is one served scalar, identified by its OID without the .0.
+type snmpObject struct {
+	oid   []int
+	value []byte
+}
+
+func snmpObjects(base []int, s SNMPStats) []snmpObject {
+	scalar := func(n int, value []byte) snmpObject {
+		oid := append(append([]int{}, base...), n, 0)
+		return snmpObject{oid: oid, value: value}
+	}
+	connected := 0
+	if s.Connected {
+		connected = 1
+	}
+	return []snmpObject{
+		scalar(1, berTLV(berGauge32, berUint(clampUint32(s.Hashrate)))),
+		scalar(2, berTLV(berInteger, berInt(int64(math.Round(s.Temperature*10))))),
+		scalar(3, berTLV(berCounter64, berUint(s.Blocks[0]))),
+		scalar(4, berTLV(berCounter64, berUint(s.Blocks[1]))),
+		scalar(5, berTLV(berCounter64, berUint(s.Blocks[2]))),
+		scalar(6, berTLV(berInteger, berInt(int64(connected)))),
+		scalar(7, berTLV(berGauge32, berUint(clampUint32(float64(s.Threads))))),
+	}
+}
+
+func handleSNMP(packet []byte, community string, base []int, stats func() SNMPStats) ([]byte, error) {
+	tag, msg, _, err := readBER(packet)
+	if err != nil || tag != berSequence {
+		return nil, errBER
+	}
+	tag, versionBytes, msg, err := readBER(msg)
+	if err != nil || tag != berInteger {
+		return nil, errBER
+	}
+	version := parseBERInt(versionBytes)
+	if version != snmpVersion1 && version != snmpVersion2c {
+		return nil, errBER
+	}
+	tag, comm, msg, err := readBER(msg)
+	if err != nil || tag != berOctetString || string(comm) != community {
+		return nil, errBER
+	}
+	pduType, pdu, _, err := readBER(msg)
+	if err != nil || (pduType != pduGetRequest && pduType != pduGetNextRequest) {
+		return nil, errBER
+	}
+	tag, requestID, pdu, err := readBER(pdu)
+	if err != nil || tag != berInteger {
+		return nil, errBER
+	}
+	// Skip error-status and error-index.
+	for i := 0; i < 2; i++ {
+		if _, _, pdu, err = readBER(pdu); err != nil {
+			return nil, err
+		}
+	}
+	tag, varbinds, _, err := readBER(pdu)
+	if err != nil || tag != berSequence {
+		return nil, errBER
+	}
+
+	objects := snmpObjects(base, stats())
+	if version == snmpVersion1 {
+		// SNMPv1 has no Counter64, so those objects don't exist for it and
+		// GETNEXT walks past them (RFC 2576 4.1.2.1).
+		v1 := objects[:0:0]
+		for _, object := range objects {
+			if object.value[0] != berCounter64 {
+				v1 = append(v1, object)
+			}
+		}
+		objects = v1
+	}
+	var (
+		out         []byte
+		errorStatus int64
+		errorIndex  int64
+	)
+	for index := int64(1); len(varbinds) > 0; index++ {
+		var varbind []byte
+		tag, varbind, varbinds, err = readBER(varbinds)
+		if err != nil || tag != berSequence {
+			return nil, errBER
+		}
+		tag, oidBytes, _, err := readBER(varbind)
+		if err != nil || tag != berOID {
+			return nil, errBER
+		}
+		oid, err := decodeOID(oidBytes)
+		if err != nil {
+			return nil, err
+		}
+		var value []byte
+		if pduType == pduGetRequest {
+			value = berTLV(berNoSuchObject, nil)
+			for _, object := range objects {
+				if compareOID(object.oid, oid) == 0 {
+					value = object.value
+					break
+				}
+			}
+		} else {
+			value = berTLV(berEndOfMibView, nil)
+			i := sort.Search(len(objects), func(i int) bool { return compareOID(objects[i].oid, oid) > 0 })
+			if i < len(objects) {
+				oid, value = objects[i].oid, objects[i].value
+			}
+		}
+		if version == snmpVersion1 && (value[0] == berNoSuchObject || value[0] == berEndOfMibView) {
+			// SNMPv1 has no exception values, report the first bad varbind.
+			if errorStatus == 0 {
+				errorStatus, errorIndex = snmpNoSuchName, index
+			}
+			value = berTLV(berNull, nil)
+		}
+		out = append(out, berTLV(berSequence, append(berTLV(berOID, encodeOID(oid)), value...))...)
+	}
+
+	var body []byte
+	body = append(body, berTLV(berInteger, requestID)...)
+	body = append(body, berTLV(berInteger, berInt(errorStatus))...)
+	body = append(body, berTLV(berInteger, berInt(errorIndex))...)
+	body = append(body, berTLV(berSequence, out)...)
+	var resp []byte
+	resp = append(resp, berTLV(berInteger, berInt(version))...)
+	resp = append(resp, berTLV(berOctetString, []byte(community))...)
+	resp = append(resp, berTLV(pduGetResponse, body)...)
+	return berTLV(berSequence, resp), nil
+}
+
+// readBER splits the first TLV off b.
+func readBER(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBER
+	}
+	tag, length, b := b[0], int(b[1]), b[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 3 || len(b) < n {
+			return 0, nil, nil, errBER
+		}
+		length = 0
+		for _, c := range b[:n] {
+			length = length<<8 | int(c)
+		}
+		b = b[n:]
+	}
+	if length > len(b) {
+		return 0, nil, nil, errBER
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	out := []byte{tag}
+	switch n := len(value); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	case n <= 0xff:
+		out = append(out, 0x81, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, value...)
+}
+
+// berInt encodes a signed integer in minimal two's complement.
+func berInt(v int64) []byte {
+	out := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		out = append([]byte{byte(v)}, out...)
+	}
+	return out
+}
+
+// berUint encodes an unsigned integer, with a leading zero when the high bit
+// is set so it isn't read as negative.
+func berUint(v uint64) []byte {
+	var out []byte
+	for {
+		out = append([]byte{byte(v)}, out...)
+		v >>= 8
+		if v == 0 {
+			break
+		}
+	}
+	if out[0]&0x80 != 0 {
+		out = append([]byte{0}, out...)
+	}
+	return out
+}
+
+func parseBERInt(b []byte) int64 {
+	var v int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+func clampUint32(v float64) uint64 {
+	if v < 0 {
+		return 0
+	}
+	if v > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint64(v)
+}
+
+func parseOID(s string) ([]int, error) {
+	var oid []int
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, n)
+	}
+	return oid, nil
+}
+
+func encodeOID(oid []int) []byte {
+	if len(oid) < 2 {
+		return []byte{0}
+	}
+	out := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		chunk := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			chunk = append([]byte{byte(n&0x7f) | 0x80}, chunk...)
+		}
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+func decodeOID(b []byte) ([]int, error) {
+	if len(b) == 0 {
+		return nil, errBER
+	}
+	oid := []int{int(b[0]) / 40, int(b[0]) % 40}
+	n := 0
+	for i, c := range b[1:] {
+		if n > math.MaxInt32>>7 {
+			return nil, errBER
+		}
+		n = n<<7 | int(c&0x7f)
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		} else if i == len(b)-2 {
+			return nil, errBER
+		}
+	}
+	return oid, nil
+}
+
+func compareOID(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Spool is an append-only file of submissions that couldn't be delivered,
+// kept across restarts until they are resent.
+type Spool struct {
+	mu   sync.Mutex
+	path string
+}
+
+// spoolEntry is one spooled submission and when it was found.
+type spoolEntry struct {
+	Time   int64         `json:"time"`
+	Params []interface{} `json:"params"`
+}
+
+// NewSpool returns a spool backed by the file at path.
+func NewSpool(path string) *Spool {
+	return &Spool{path: path}
+}
+
+// Add appends a submission's request parameters.
+func (s *Spool) Add(params []interface{}) error {
+	data, err := json.Marshal(spoolEntry{Time: time.Now().Unix(), Params: params})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// Drain returns the spooled submissions and empties the spool. Submissions
+// spooled more than a few blocks ago are stale and dropped.
+func (s *Spool) Drain() ([][]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var entries [][]interface{}
+	cutoff := time.Now().Add(-c_Max_Spool_Age).Unix()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, c_Max_Req_Size), c_Max_Msg_Size)
+	for scanner.Scan() {
+		var entry spoolEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Time < cutoff {
+			log.Debugf("Dropping stale spooled submission from %s", time.Unix(entry.Time, 0))
+			continue
+		}
+		entries = append(entries, entry.Params)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, os.Remove(s.path)
+}
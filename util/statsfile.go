@@ -0,0 +1,106 @@
+package util
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stats file formats.
+const (
+	StatsFormatCSV  = "csv"
+	StatsFormatJSON = "json"
+)
+
+var statsColumns = []string{"time", "hashrate", "threads", "blocks_prime", "blocks_region", "blocks_zone", "accepted", "rejected", "stale", "invalid", "reconnects"}
+
+// StatsRecord is one snapshot of the session appended to the stats file.
+type StatsRecord struct {
+	Time        time.Time         `json:"time"`
+	Hashrate    float64           `json:"hashrate"`
+	Threads     int               `json:"threads"`
+	Blocks      map[string]uint64 `json:"blocks"`
+	Submissions SubmissionStats   `json:"submissions"`
+	Invalid     uint64            `json:"invalid"`
+	Reconnects  uint64            `json:"reconnects"`
+}
+
+// StatsWriter appends snapshots to a CSV file, or JSON lines.
+type StatsWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	format string
+	csv    *csv.Writer
+}
+
+// StatsFileFormat is the format set, else json for .json and .jsonl paths and
+// csv for anything else.
+func StatsFileFormat(path, format string) string {
+	if format != "" {
+		return format
+	}
+	switch filepath.Ext(path) {
+	case ".json", ".jsonl":
+		return StatsFormatJSON
+	}
+	return StatsFormatCSV
+}
+
+// OpenStatsFile opens the stats file for appending, writing the CSV header
+// when the file is new.
+func OpenStatsFile(path, format string) (*StatsWriter, error) {
+	format = StatsFileFormat(path, format)
+	if format != StatsFormatCSV && format != StatsFormatJSON {
+		return nil, fmt.Errorf("unknown stats file format %q", format)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &StatsWriter{file: file, format: format}
+	if format == StatsFormatCSV {
+		w.csv = csv.NewWriter(file)
+		if info, err := file.Stat(); err == nil && info.Size() == 0 {
+			w.csv.Write(statsColumns)
+			w.csv.Flush()
+		}
+	}
+	return w, nil
+}
+
+// Write appends a snapshot.
+func (w *StatsWriter) Write(r StatsRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.format == StatsFormatJSON {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		_, err = w.file.Write(append(line, '\n'))
+		return err
+	}
+	u := func(n uint64) string { return strconv.FormatUint(n, 10) }
+	w.csv.Write([]string{
+		r.Time.UTC().Format(time.RFC3339),
+		strconv.FormatFloat(r.Hashrate, 'f', 2, 64),
+		strconv.Itoa(r.Threads),
+		u(r.Blocks["prime"]), u(r.Blocks["region"]), u(r.Blocks["zone"]),
+		u(r.Submissions.Accepted), u(r.Submissions.Rejected), u(r.Submissions.Stale),
+		u(r.Invalid), u(r.Reconnects),
+	})
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// Close closes the file.
+func (w *StatsWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
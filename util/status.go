@@ -0,0 +1,81 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+// StatusReport is the miner state served as JSON on /status.
+type StatusReport struct {
+	Mode        string            `json:"mode"`
+	Location    []int             `json:"location"`
+	Number      [3]uint64         `json:"number"`
+	Hashrate    float64           `json:"hashrate"`
+	Hashrates   HashrateSummary   `json:"hashrates"`
+	Threads     int               `json:"threads"`
+	Blocks      map[string]uint64 `json:"blocks"`
+	Submissions SubmissionStats   `json:"submissions"`
+	Connected   bool              `json:"connected"`
+	Paused      bool              `json:"paused"`
+	Uptime      float64           `json:"uptime"` // seconds
+	Zones       []StatusReport    `json:"zones,omitempty"`
+}
+
+// BlocksByContext names the per-context block counts for a status report.
+func BlocksByContext(blocks [3]uint64) map[string]uint64 {
+	named := make(map[string]uint64, len(blocks))
+	for ctx, n := range blocks {
+		named[ContextName(ctx)] = n
+	}
+	return named
+}
+
+// LocationIndices lists a location's region and zone indices, which would
+// otherwise marshal as base64 bytes.
+func LocationIndices(location common.Location) []int {
+	indices := make([]int, len(location))
+	for i, index := range location {
+		indices[i] = int(index)
+	}
+	return indices
+}
+
+// ProbePaths are the status listener's health probes. They answer from any
+// client so orchestrators probing over the pod network aren't refused, and
+// reveal nothing beyond whether the check passes.
+var ProbePaths = []string{"/healthz", "/readyz"}
+
+// NewStatusHandler serves GET /status as JSON, and the /healthz (liveness)
+// and /readyz (readiness) probes, answering 200 while the check passes and
+// 503 with the problem otherwise.
+func NewStatusHandler(status func() StatusReport, live, ready func() error) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status())
+	})
+	mux.Handle("/healthz", probeHandler(live))
+	mux.Handle("/readyz", probeHandler(ready))
+	return mux
+}
+
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	}
+}
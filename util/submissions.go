@@ -0,0 +1,72 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+// Sealhashes remembered by a SubmittedSet.
+const c_Submitted_History = 256
+
+// SubmissionStats counts the outcome of every submission. Stale submissions
+// were rejected because newer work had already replaced theirs.
+type SubmissionStats struct {
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"`
+	Stale    uint64 `json:"stale"`
+}
+
+// Total is the number of submissions with an outcome.
+func (s SubmissionStats) Total() uint64 {
+	return s.Accepted + s.Rejected + s.Stale
+}
+
+func (s SubmissionStats) String() string {
+	accepted := fmt.Sprint(s.Accepted)
+	if total := s.Total(); total > 0 {
+		accepted += fmt.Sprintf(" (%.1f%%)", 100*float64(s.Accepted)/float64(total))
+	}
+	return fmt.Sprintf("accepted %s, rejected %d, stale %d", accepted, s.Rejected, s.Stale)
+}
+
+// IsStaleRejection reports whether an upstream rejection blames outdated work.
+func IsStaleRejection(err error) bool {
+	if err == nil {
+		return false
+	}
+	reason := strings.ToLower(err.Error())
+	return strings.Contains(reason, "stale") || strings.Contains(reason, "old work") || strings.Contains(reason, "unknown work")
+}
+
+// SubmittedSet remembers the sealhashes of the latest solutions handed on for
+// submission, so a solution for work already solved isn't submitted twice.
+// Proxies ban workers that keep sending duplicates. The zero value is ready
+// to use.
+type SubmittedSet struct {
+	mu     sync.Mutex
+	seen   map[common.Hash]struct{}
+	recent []common.Hash // oldest first
+}
+
+// Add records a solution's sealhash, reporting false if it was already
+// submitted.
+func (s *SubmittedSet) Add(sealHash common.Hash) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.seen[sealHash]; ok {
+		return false
+	}
+	if s.seen == nil {
+		s.seen = make(map[common.Hash]struct{})
+	}
+	s.seen[sealHash] = struct{}{}
+	s.recent = append(s.recent, sealHash)
+	if len(s.recent) > c_Submitted_History {
+		delete(s.seen, s.recent[0])
+		s.recent = s.recent[1:]
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package util
+
+import (
+	"errors"
+	"time"
+)
+
+// LoadAverage is only supported on Linux.
+func LoadAverage() (float64, error) {
+	return 0, errors.New("reading the system load is not supported on this platform")
+}
+
+// ProcessCPUTime is only supported on Linux.
+func ProcessCPUTime() (time.Duration, error) {
+	return 0, errors.New("reading the process CPU time is not supported on this platform")
+}
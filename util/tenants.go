@@ -0,0 +1,105 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// Tenant is one participant sharing the rig, credited for Weight parts of
+// the mining time.
+type Tenant struct {
+	Name    string
+	Address string
+	Weight  int
+}
+
+// TenantStats is a tenant's attributed mining time and the blocks found, per
+// context, while mining to its address.
+type TenantStats struct {
+	Name    string
+	Address string
+	Time    time.Duration
+	Blocks  [3]uint64
+}
+
+// Tenants attributes the rig's work to one tenant at a time, switching by
+// smooth weighted round-robin so each tenant gets its Weight share of the
+// time, and keeps per-tenant statistics.
+type Tenants struct {
+	lock    sync.Mutex
+	rotator *AddressRotator
+	stats   []TenantStats
+	current int
+	since   time.Time
+}
+
+func NewTenants(tenants []Tenant) *Tenants {
+	addresses := make([]WeightedAddress, len(tenants))
+	t := &Tenants{stats: make([]TenantStats, len(tenants))}
+	for i, tenant := range tenants {
+		addresses[i] = WeightedAddress{Address: tenant.Address, Weight: tenant.Weight}
+		name := tenant.Name
+		if name == "" {
+			name = tenant.Address
+		}
+		t.stats[i] = TenantStats{Name: name, Address: tenant.Address}
+	}
+	t.rotator = NewAddressRotator(addresses)
+	t.current = t.rotator.nextIndex()
+	t.since = time.Now()
+	return t
+}
+
+// Current returns the tenant being mined for.
+func (t *Tenants) Current() TenantStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.stats[t.current]
+}
+
+// Next credits the elapsed time to the current tenant and moves on to the
+// next one in the rotation.
+func (t *Tenants) Next() TenantStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.stats[t.current].Time += now.Sub(t.since)
+	t.since = now
+	t.current = t.rotator.nextIndex()
+	return t.stats[t.current]
+}
+
+// AddBlock credits a block of the given order to the current tenant.
+func (t *Tenants) AddBlock(order int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stats[t.current].Blocks[order]++
+}
+
+// Stats returns every tenant's statistics, including the current tenant's
+// time so far.
+func (t *Tenants) Stats() []TenantStats {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	stats := append([]TenantStats(nil), t.stats...)
+	stats[t.current].Time += time.Since(t.since)
+	return stats
+}
+
+// Restore adds previously saved statistics to the tenants with the same
+// address.
+func (t *Tenants) Restore(saved []TenantStats) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, s := range saved {
+		for i := range t.stats {
+			if t.stats[i].Address == s.Address {
+				t.stats[i].Time += s.Time
+				for j := range s.Blocks {
+					t.stats[i].Blocks[j] += s.Blocks[j]
+				}
+				break
+			}
+		}
+	}
+}
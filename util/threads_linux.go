@@ -0,0 +1,29 @@
+//go:build linux
+
+package util
+
+import (
+	"os"
+	"strconv"
+)
+
+// forEachThread calls fn with the id of every thread in the process. Per-thread
+// attributes such as affinity and nice value are inherited by threads the Go
+// runtime spawns later, so applying them to all existing threads covers the
+// whole process.
+func forEachThread(fn func(tid int) error) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := fn(tid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"time"
+
+	"github.com/dominant-strategies/go-quai/core/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Duty cycle window of a throttled engine.
+const c_Throttle_Window = 100 * time.Millisecond
+
+// ThrottledEngine duty-cycles the sealing of the engine it wraps: in every
+// window it seals for percent of the time and idles for the rest, so each
+// sealing thread uses about percent of a core.
+type ThrottledEngine struct {
+	PowEngine
+	active time.Duration
+	idle   time.Duration
+}
+
+// NewThrottledEngine wraps engine to seal percent (0-100) of the time.
+func NewThrottledEngine(engine PowEngine, percent float64) *ThrottledEngine {
+	active := time.Duration(float64(c_Throttle_Window) * percent / 100)
+	return &ThrottledEngine{PowEngine: engine, active: active, idle: c_Throttle_Window - active}
+}
+
+// Seal seals the header in bursts until a solution is found or stop closes.
+func (t *ThrottledEngine) Seal(header *types.Header, results chan<- *types.Header, stop <-chan struct{}) error {
+	found := make(chan *types.Header, 1)
+	burst := make(chan struct{})
+	if err := t.PowEngine.Seal(header, found, burst); err != nil {
+		return err
+	}
+	go t.dutyCycle(header, found, burst, results, stop)
+	return nil
+}
+
+func (t *ThrottledEngine) dutyCycle(header *types.Header, found chan *types.Header, burst chan struct{}, results chan<- *types.Header, stop <-chan struct{}) {
+	deliver := func(result *types.Header) {
+		select {
+		case results <- result:
+		case <-stop:
+		}
+	}
+	for {
+		timer := time.NewTimer(t.active)
+		select {
+		case result := <-found:
+			timer.Stop()
+			deliver(result)
+			return
+		case <-stop:
+			timer.Stop()
+			close(burst)
+			return
+		case <-timer.C:
+			close(burst)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(t.idle):
+		}
+		// A solution may have landed as the burst was stopped.
+		select {
+		case result := <-found:
+			deliver(result)
+			return
+		default:
+		}
+		burst = make(chan struct{})
+		if err := t.PowEngine.Seal(header, found, burst); err != nil {
+			log.Errorln("Block sealing failed", "err", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+const (
+	// Traces of work kept while waiting for a solution; older ones are
+	// dropped unexported.
+	c_Trace_History = 64
+	// How long after its solution a trace waits for outstanding acks.
+	c_Trace_Linger = 10 * time.Second
+)
+
+var tracingClient = &http.Client{Timeout: 10 * time.Second}
+
+// workTrace is the lifecycle of one work package, from its arrival to the
+// acks of its solution.
+type workTrace struct {
+	traceID  [16]byte
+	rootID   [8]byte
+	number   []uint64
+	received time.Time
+	sealed   time.Time
+	solved   time.Time
+	order    int
+	hash     common.Hash
+	submits  map[string]*submitSpan
+}
+
+type submitSpan struct {
+	start time.Time
+	end   time.Time
+	err   string
+}
+
+// Tracer turns the work lifecycle into OpenTelemetry traces exported over
+// OTLP/HTTP with the JSON encoding: a "work" span per solved work package with
+// "queue" (received until sealing started), "seal" (until solved), "verify"
+// (until first submitted) and one "submit" span per target until its ack.
+// Work that is never solved isn't exported. A nil tracer ignores every call.
+type Tracer struct {
+	endpoint string
+	headers  map[string]string
+	resource map[string]string
+
+	mu     sync.Mutex
+	traces map[common.Hash]*workTrace // by the sealhash of the work and of every stamped version of it
+	recent []common.Hash              // work sealhashes, oldest first
+}
+
+// NewTracer exports to the OTLP traces endpoint, e.g.
+// http://127.0.0.1:4318/v1/traces, sending headers with every request and
+// attaching the resource attributes to every span.
+func NewTracer(endpoint string, headers, resource map[string]string) *Tracer {
+	return &Tracer{endpoint: endpoint, headers: headers, resource: resource, traces: make(map[common.Hash]*workTrace)}
+}
+
+// Received starts the trace of newly arrived work.
+func (t *Tracer) Received(header *types.Header) {
+	if t == nil {
+		return
+	}
+	sealHash := header.SealHash()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.traces[sealHash]; ok {
+		return
+	}
+	trace := &workTrace{received: time.Now(), submits: make(map[string]*submitSpan)}
+	rand.Read(trace.traceID[:])
+	rand.Read(trace.rootID[:])
+	for _, n := range header.NumberArray() {
+		trace.number = append(trace.number, n.Uint64())
+	}
+	t.traces[sealHash] = trace
+	t.recent = append(t.recent, sealHash)
+	if len(t.recent) > c_Trace_History {
+		t.forget(t.recent[0])
+		t.recent = t.recent[1:]
+	}
+}
+
+// Sealed records that sealing started on the work, under the sealhash it got
+// once stamped.
+func (t *Tracer) Sealed(work, stamped common.Hash) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace := t.traces[work]
+	if trace == nil {
+		return
+	}
+	if trace.sealed.IsZero() {
+		trace.sealed = time.Now()
+	}
+	t.traces[stamped] = trace
+}
+
+// Solved records a verified solution.
+func (t *Tracer) Solved(header *types.Header, order int) {
+	if trace := t.trace(header.SealHash()); trace != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if trace.solved.IsZero() {
+			trace.solved, trace.order, trace.hash = time.Now(), order, header.Hash()
+		}
+	}
+}
+
+// Submitted records that the solution was sent to target.
+func (t *Tracer) Submitted(sealHash common.Hash, target string) {
+	if trace := t.trace(sealHash); trace != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := trace.submits[target]; !ok {
+			trace.submits[target] = &submitSpan{start: time.Now()}
+		}
+	}
+}
+
+// Acked records target's answer to the solution.
+func (t *Tracer) Acked(sealHash common.Hash, target string, err error) {
+	if trace := t.trace(sealHash); trace != nil {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		submit := trace.submits[target]
+		if submit == nil || !submit.end.IsZero() {
+			return
+		}
+		submit.end = time.Now()
+		if err != nil {
+			submit.err = err.Error()
+		}
+	}
+}
+
+func (t *Tracer) trace(sealHash common.Hash) *workTrace {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.traces[sealHash]
+}
+
+// forget drops a trace under every sealhash it is known by.
+func (t *Tracer) forget(sealHash common.Hash) {
+	trace := t.traces[sealHash]
+	for hash, other := range t.traces {
+		if other == trace {
+			delete(t.traces, hash)
+		}
+	}
+}
+
+// Flush exports the traces whose acks are all in or that lingered long
+// enough after their solution, every solved one when final.
+func (t *Tracer) Flush(final bool) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	var done []*workTrace
+	for i := 0; i < len(t.recent); i++ {
+		trace := t.traces[t.recent[i]]
+		if trace == nil || trace.solved.IsZero() {
+			continue
+		}
+		if !final && !trace.acked() && time.Since(trace.solved) < c_Trace_Linger {
+			continue
+		}
+		done = append(done, trace)
+		t.forget(t.recent[i])
+		t.recent = append(t.recent[:i], t.recent[i+1:]...)
+		i--
+	}
+	t.mu.Unlock()
+	if len(done) == 0 {
+		return nil
+	}
+	var spans []otlpSpan
+	for _, trace := range done {
+		spans = append(spans, trace.spans()...)
+	}
+	return t.export(spans)
+}
+
+// acked reports whether every submission of the solution got an answer.
+func (w *workTrace) acked() bool {
+	if len(w.submits) == 0 {
+		return false
+	}
+	for _, submit := range w.submits {
+		if submit.end.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// spans lays the trace out as the root span and its children.
+func (w *workTrace) spans() []otlpSpan {
+	traceID := hex.EncodeToString(w.traceID[:])
+	rootID := hex.EncodeToString(w.rootID[:])
+	child := func(name string, start, end time.Time, attrs ...otlpAttribute) otlpSpan {
+		var id [8]byte
+		rand.Read(id[:])
+		return newSpan(traceID, hex.EncodeToString(id[:]), rootID, name, start, end, attrs...)
+	}
+	sealed := w.sealed
+	if sealed.IsZero() {
+		sealed = w.received
+	}
+	end := w.solved
+	var children []otlpSpan
+	children = append(children, child("queue", w.received, sealed), child("seal", sealed, w.solved))
+	targets := make([]string, 0, len(w.submits))
+	for target := range w.submits {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	var firstSubmit time.Time
+	for _, target := range targets {
+		submit := w.submits[target]
+		if firstSubmit.IsZero() || submit.start.Before(firstSubmit) {
+			firstSubmit = submit.start
+		}
+		submitEnd := submit.end
+		attrs := []otlpAttribute{stringAttribute("quai.target", target)}
+		if submitEnd.IsZero() {
+			submitEnd = submit.start
+			attrs = append(attrs, stringAttribute("quai.ack", "none"))
+		}
+		span := child("submit "+target, submit.start, submitEnd, attrs...)
+		if submit.err != "" {
+			span.Status = &otlpStatus{Code: 2, Message: submit.err}
+		}
+		children = append(children, span)
+		if submitEnd.After(end) {
+			end = submitEnd
+		}
+	}
+	if !firstSubmit.IsZero() {
+		children = append(children, child("verify", w.solved, firstSubmit))
+	}
+	root := newSpan(traceID, rootID, "", "work", w.received, end,
+		stringAttribute("quai.number", fmt.Sprint(w.number)),
+		stringAttribute("quai.context", ContextName(w.order)),
+		stringAttribute("quai.hash", w.hash.Hex()))
+	return append([]otlpSpan{root}, children...)
+}
+
+// OTLP/JSON encoding of spans, see opentelemetry-proto's trace.proto.
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func newSpan(traceID, spanID, parentID, name string, start, end time.Time, attrs ...otlpAttribute) otlpSpan {
+	return otlpSpan{
+		TraceID:           traceID,
+		SpanID:            spanID,
+		ParentSpanID:      parentID,
+		Name:              name,
+		Kind:              1, // internal
+		StartTimeUnixNano: strconv.FormatInt(start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        attrs,
+	}
+}
+
+func stringAttribute(key, value string) otlpAttribute {
+	attr := otlpAttribute{Key: key}
+	attr.Value.StringValue = value
+	return attr
+}
+
+// export posts the spans to the OTLP endpoint.
+func (t *Tracer) export(spans []otlpSpan) error {
+	keys := make([]string, 0, len(t.resource))
+	for key := range t.resource {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var resource []otlpAttribute
+	for _, key := range keys {
+		resource = append(resource, stringAttribute(key, t.resource[key]))
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   map[string]interface{}{"attributes": resource},
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "quai-cpu-miner"}, "spans": spans}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := tracingClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("OTLP endpoint returned %s", resp.Status)
+	}
+	return nil
+}
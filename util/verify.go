@@ -0,0 +1,75 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+var big2e256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), nil)
+
+// SolutionReport is the outcome of re-verifying a sealed header locally.
+type SolutionReport struct {
+	SealHash       common.Hash
+	PowHash        common.Hash
+	MixHash        common.Hash
+	ClaimedMixHash common.Hash
+	Target         *big.Int
+	MeetsTarget    bool
+	Order          int
+	Err            error
+}
+
+// LoadHeader reads a JSON encoded header, as recorded from the node or proxy.
+func LoadHeader(path string) (*types.Header, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var header *types.Header
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// VerifySolution recomputes the proof of work of the header from scratch and
+// compares it against the header's target.
+func VerifySolution(engine PowEngine, header *types.Header) SolutionReport {
+	header = types.CopyHeader(header)
+	report := SolutionReport{
+		SealHash:       header.SealHash(),
+		ClaimedMixHash: header.MixHash(),
+		Order:          -1,
+	}
+	if header.Difficulty().Sign() > 0 {
+		report.Target = new(big.Int).Div(big2e256, header.Difficulty())
+	}
+	_, report.Order, report.Err = engine.CalcOrder(header)
+	// The engine caches the digests it computed while verifying.
+	if powHash, ok := header.PowHash.Load().(common.Hash); ok {
+		report.PowHash = powHash
+		report.MeetsTarget = report.Target != nil && new(big.Int).SetBytes(powHash.Bytes()).Cmp(report.Target) <= 0
+	}
+	if mixHash, ok := header.PowDigest.Load().(common.Hash); ok {
+		report.MixHash = mixHash
+	}
+	return report
+}
+
+// Problem explains why the solution is invalid, or is nil for a valid one.
+func (r SolutionReport) Problem() error {
+	switch {
+	case r.Err != nil:
+		return r.Err
+	case !r.MeetsTarget:
+		return errors.New("pow hash above the target")
+	case r.MixHash != (common.Hash{}) && r.MixHash != r.ClaimedMixHash:
+		return errors.New("mix hash mismatch")
+	}
+	return nil
+}
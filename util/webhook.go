@@ -0,0 +1,117 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+const (
+	c_Webhook_Retries         = 5
+	c_Webhook_Retry_Delay     = time.Second
+	c_Webhook_Max_Retry_Delay = time.Minute
+)
+
+// BlockWebhook posts every found block of at least Context ("zone", "region"
+// or "prime", zone when empty) to URL, retrying failed deliveries up to
+// Retries times with exponential backoff.
+type BlockWebhook struct {
+	URL     string
+	Context string
+	Retries int
+}
+
+// BlockNotification is the payload posted for a found block. Content is a
+// readable summary for chat webhooks such as Discord.
+type BlockNotification struct {
+	Context   string      `json:"context"`
+	Number    []uint64    `json:"number"`
+	Hash      common.Hash `json:"hash"`
+	Timestamp int64       `json:"timestamp"`
+	Location  []int       `json:"location"`
+	Content   string      `json:"content"`
+}
+
+// ContextIndex is the inverse of the context names used in payloads and
+// config, reporting false for unknown names.
+func ContextIndex(name string) (int, bool) {
+	switch name {
+	case "prime":
+		return common.PRIME_CTX, true
+	case "region":
+		return common.REGION_CTX, true
+	case "zone", "":
+		return common.ZONE_CTX, true
+	}
+	return 0, false
+}
+
+// Notifies reports whether a block of the order is posted.
+func (w BlockWebhook) Notifies(order int) bool {
+	threshold, _ := ContextIndex(w.Context)
+	return w.URL != "" && order <= threshold
+}
+
+// NewBlockNotification describes a block found at the order.
+func NewBlockNotification(order int, number []uint64, hash common.Hash, location common.Location) BlockNotification {
+	return BlockNotification{
+		Context:   ContextName(order),
+		Number:    number,
+		Hash:      hash,
+		Timestamp: time.Now().Unix(),
+		Location:  LocationIndices(location),
+		Content:   fmt.Sprintf("Found a %s block %v in location %v: %s", ContextName(order), number, LocationIndices(location), hash.Hex()),
+	}
+}
+
+// Post delivers the notification, retrying network errors and server-side
+// failures. Client errors other than rate limiting aren't retried.
+func (w BlockWebhook) Post(n BlockNotification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	retries := w.Retries
+	if retries <= 0 {
+		retries = c_Webhook_Retries
+	}
+	backoff := NewBackoff(c_Webhook_Retry_Delay, c_Webhook_Max_Retry_Delay)
+	for attempt := 0; ; attempt++ {
+		err = w.post(data)
+		if err == nil || attempt == retries {
+			return err
+		}
+		if status, ok := err.(webhookStatusError); ok && status < 500 && status != http.StatusTooManyRequests {
+			return err
+		}
+		time.Sleep(backoff.Next())
+	}
+}
+
+type webhookStatusError int
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook returned %d %s", int(e), http.StatusText(int(e)))
+}
+
+func (w BlockWebhook) post(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c_Hook_Timeout)
+	defer cancel()
+	req, err := newJSONRequest(ctx, w.URL, data)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return webhookStatusError(resp.StatusCode)
+	}
+	return nil
+}
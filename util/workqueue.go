@@ -0,0 +1,58 @@
+package util
+
+import (
+	"sync"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+// WorkQueue hands the sealer the freshest work. It holds at most one job:
+// newer work supersedes a job that hasn't been picked up yet, and work with
+// the same sealhash as the queued or last handed out job is dropped.
+type WorkQueue struct {
+	mu       sync.Mutex
+	next     *types.Header
+	nextSeal common.Hash
+	lastSeal common.Hash
+	ready    chan struct{}
+}
+
+// NewWorkQueue returns an empty queue.
+func NewWorkQueue() *WorkQueue {
+	return &WorkQueue{ready: make(chan struct{}, 1)}
+}
+
+// Push queues the header, reporting false if it duplicates queued or
+// current work.
+func (q *WorkQueue) Push(header *types.Header) bool {
+	sealHash := header.SealHash()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if sealHash == q.lastSeal || (q.next != nil && sealHash == q.nextSeal) {
+		return false
+	}
+	q.next, q.nextSeal = header, sealHash
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Ready is signalled when work is queued.
+func (q *WorkQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Pop takes the queued work, nil if there is none.
+func (q *WorkQueue) Pop() *types.Header {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	header := q.next
+	if header != nil {
+		q.lastSeal = q.nextSeal
+		q.next = nil
+	}
+	return header
+}
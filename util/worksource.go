@@ -0,0 +1,136 @@
+package util
+
+import (
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/dominant-strategies/go-quai-stratum/rpc"
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+// LocalWorkSource is an in-process getwork endpoint serving synthetic work
+// at a chosen difficulty and verifying the solutions submitted to it. Every
+// accepted solution advances the work to the next zone block, the way a node
+// would. It lets the whole pipeline run without a node or proxy.
+type LocalWorkSource struct {
+	engine   PowEngine
+	listener net.Listener
+
+	mu     sync.Mutex
+	header *types.Header
+
+	// Solutions receives the verification report of every submission.
+	Solutions chan SolutionReport
+}
+
+// NewLocalWorkSource starts serving work at difficulty on a loopback port.
+func NewLocalWorkSource(engine PowEngine, difficulty int64, location common.Location) (*LocalWorkSource, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &LocalWorkSource{
+		engine:    engine,
+		listener:  listener,
+		header:    syntheticHeader(big.NewInt(difficulty), location),
+		Solutions: make(chan SolutionReport, 16),
+	}
+	go http.Serve(listener, s)
+	return s, nil
+}
+
+// URL is the getwork endpoint to point the miner at.
+func (s *LocalWorkSource) URL() string {
+	return "http://" + s.listener.Addr().String()
+}
+
+// Close stops serving.
+func (s *LocalWorkSource) Close() error {
+	return s.listener.Close()
+}
+
+func (s *LocalWorkSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := rpc.JsonRPCResponse{ID: req.ID}
+	var result interface{}
+	switch req.Method {
+	case "eth_getWork":
+		s.mu.Lock()
+		result = s.header.RPCMarshalHeader()
+		s.mu.Unlock()
+	case "eth_submitWork":
+		result = s.submit(req.Params)
+	default:
+		resp.Error = &rpc.JsonError{Code: c_Method_Not_Found, Message: "method not found"}
+	}
+	if result != nil {
+		raw, _ := json.Marshal(result)
+		msg := json.RawMessage(raw)
+		resp.Result = &msg
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// submit verifies a nonce, sealhash and mix digest against the served work.
+func (s *LocalWorkSource) submit(params []json.RawMessage) bool {
+	if len(params) != 3 {
+		return false
+	}
+	var (
+		nonce    types.BlockNonce
+		sealHash common.Hash
+		mixHash  common.Hash
+	)
+	if json.Unmarshal(params[0], &nonce) != nil || json.Unmarshal(params[1], &sealHash) != nil || json.Unmarshal(params[2], &mixHash) != nil {
+		return false
+	}
+	s.mu.Lock()
+	header := types.CopyHeader(s.header)
+	s.mu.Unlock()
+	if header.SealHash() != sealHash {
+		return false
+	}
+	header.SetNonce(nonce)
+	header.SetMixHash(&mixHash)
+	report := VerifySolution(s.engine, header)
+	select {
+	case s.Solutions <- report:
+	default:
+	}
+	accepted := report.Err == nil && report.MeetsTarget
+	if accepted {
+		s.advance(header)
+	}
+	return accepted
+}
+
+// advance serves work on top of the solved header.
+func (s *LocalWorkSource) advance(solved *types.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.header.SealHash() != solved.SealHash() {
+		// Already advanced by another submission.
+		return
+	}
+	next := syntheticHeader(solved.Difficulty(), solved.Location())
+	for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+		next.SetNumber(solved.Number(ctx), ctx)
+		next.SetParentHash(solved.ParentHash(ctx), ctx)
+	}
+	next.SetNumber(new(big.Int).Add(solved.Number(common.ZONE_CTX), common.Big1), common.ZONE_CTX)
+	next.SetParentHash(solved.Hash(), common.ZONE_CTX)
+	s.header = next
+}